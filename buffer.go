@@ -0,0 +1,102 @@
+package bytepack
+
+import (
+	"reflect"
+	"sync"
+)
+
+const (
+	// maxPooledBufferSize is the capacity above which buffers are dropped instead of being pooled or kept
+	maxPooledBufferSize = 1 << 20
+	// estimateWeight is the inverse weight of the newest size in the running estimates
+	estimateWeight = 8
+)
+
+// Buffer
+/*
+   Buffer holds a packed message in a pooled buffer. Release returns the buffer to the pool,
+   after which neither the Buffer nor the bytes it returned may be used.
+*/
+type Buffer struct {
+	b    encBuffer
+	data []byte
+}
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(Buffer)
+	},
+}
+
+func getBuffer(size int) *Buffer {
+	b := bufferPool.Get().(*Buffer)
+	b.b.Grow(size)
+	return b
+}
+
+// Bytes returns the packed message
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Len returns the length of the packed message
+func (b *Buffer) Len() int {
+	return len(b.data)
+}
+
+// Release returns the buffer to the pool
+func (b *Buffer) Release() {
+	b.data = nil
+	b.b.Reset()
+	if b.b.Cap() > maxPooledBufferSize {
+		b.b = encBuffer{}
+	}
+	bufferPool.Put(b)
+}
+
+// PackBuffer
+/*
+   PackBuffer packs obj like Pack does, but into a pooled Buffer, so steady-state packing does not allocate.
+   The Buffer must be released once the message is no longer needed.
+*/
+func (s *Packer) PackBuffer(obj interface{}) (*Buffer, error) {
+	s.resetEncoder()
+	t := reflect.TypeOf(obj)
+	b := getBuffer(s.estimatedSize(t))
+	w := s.w
+	s.w = &b.b
+	err := s.encode(obj)
+	s.w = w
+	if err != nil {
+		b.Release()
+		return nil, err
+	}
+	s.updateEstimate(t, b.b.Len())
+	b.data = b.b.Bytes()
+	return b, nil
+}
+
+// estimatedSize returns the expected packed size of a value of type t, with some headroom
+func (s *Packer) estimatedSize(t reflect.Type) int {
+	estimate := s.sizeEstimates[t]
+	return estimate + estimate/4
+}
+
+func (s *Packer) updateEstimate(t reflect.Type, size int) {
+	if s.sizeEstimates == nil {
+		s.sizeEstimates = make(map[reflect.Type]int)
+	}
+	estimate, ok := s.sizeEstimates[t]
+	if !ok {
+		s.sizeEstimates[t] = size
+		return
+	}
+	s.sizeEstimates[t] = estimate + (size-estimate)/estimateWeight
+}
+
+// shrinkBuffer drops the internal buffer when an unusually large message left it much bigger than messages of type t need
+func (s *Packer) shrinkBuffer(t reflect.Type) {
+	if s.w.Cap() > maxPooledBufferSize && s.w.Cap() > 4*s.estimatedSize(t) {
+		s.w = new(encBuffer)
+	}
+}
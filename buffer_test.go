@@ -0,0 +1,74 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+func TestPacker_PackBuffer(t *testing.T) {
+	s := NewPacker()
+	p := &person{Name: "Tester", Age: 30, Height: 5.25}
+	expected, err := s.Pack(p)
+	assert.NoError(t, err)
+
+	b, err := s.PackBuffer(p)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, b.Bytes())
+	assert.Equal(t, len(expected), b.Len())
+
+	var p2 person
+	err = s.Unpack(b.Bytes(), &p2)
+	assert.NoError(t, err)
+	assert.Equal(t, *p, p2)
+	b.Release()
+
+	_, err = s.PackBuffer(func() {})
+	assert.Error(t, err)
+}
+
+func TestPacker_PackBufferDoesNotAllocate(t *testing.T) {
+	s := NewPacker()
+	p := &person{Name: "Tester", Age: 30, Height: 5.25}
+	allocs := testing.AllocsPerRun(100, func() {
+		b, err := s.PackBuffer(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Release()
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestPacker_SizeEstimates(t *testing.T) {
+	s := NewPacker()
+	small := newSnapshotMsg(10)
+	smallPacked, err := s.Pack(small)
+	assert.NoError(t, err)
+	snapshotType := reflect.TypeOf(small)
+	assert.Equal(t, len(smallPacked), s.sizeEstimates[snapshotType])
+	assert.GreaterOrEqual(t, s.estimatedSize(snapshotType), len(smallPacked))
+
+	// an outlier moves the estimate only a little, and its buffer is not kept around
+	large := snapshotMsg{Blob: bytes.Repeat([]byte{1}, 2*maxPooledBufferSize)}
+	_, err = s.Pack(large)
+	assert.NoError(t, err)
+	assert.Less(t, s.sizeEstimates[snapshotType], maxPooledBufferSize/2)
+	assert.LessOrEqual(t, s.w.Cap(), maxPooledBufferSize)
+}
+
+func TestBytePack_PackBuffer(t *testing.T) {
+	bp := NewBytePack(1, WithEncryption(newTestKeyRing(t, 1)), WithCRC32C())
+	m := newSnapshotMsg(100)
+
+	for i := 0; i < 3; i++ {
+		b, err := bp.PackBuffer(m)
+		assert.NoError(t, err)
+		var m2 snapshotMsg
+		err = bp.Unpack(b.Bytes(), &m2)
+		assert.NoError(t, err)
+		assert.Equal(t, m, m2)
+		b.Release()
+	}
+}
@@ -0,0 +1,251 @@
+package bytepack
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// bulkScratchSize is the size of the chunks fixed-size elements are decoded in when they cannot be read in place
+const bulkScratchSize = 4096
+
+// hostBigEndian is true when the machine stores numbers in the same byte order as the wire format
+var hostBigEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 0
+}()
+
+func (s *Packer) scratchBuffer() []byte {
+	if s.scratch == nil {
+		s.scratch = make([]byte, bulkScratchSize)
+	}
+	return s.scratch
+}
+
+// bulkUnit returns the size of the numbers elements of type t are made of when the in-memory layout of t
+// matches its encoding up to byte order, and 0 otherwise. A complex64 is made of two 4-byte numbers.
+func (s *Packer) bulkUnit(t reflect.Type) int {
+	size := s.wireSize(t.Kind())
+	if size == 0 || uintptr(size) != t.Size() {
+		return 0
+	}
+	switch t.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		return size / 2
+	}
+	return size
+}
+
+// writeFixed encodes the fixed-size elements of slice or array v.
+// Elements whose memory matches the wire format are copied in bulk, and byte-swapped on little-endian machines.
+func (s *Packer) writeFixed(v reflect.Value) error {
+	elemType := v.Type().Elem()
+	unit := s.bulkUnit(elemType)
+	if unit == 0 || (s.canonical && isFloatKind(elemType.Kind())) {
+		return s.writeFixedElements(v)
+	}
+	mem, ok := elementMemory(v)
+	if !ok {
+		return s.writeFixedElements(v)
+	}
+	if unit == 1 || hostBigEndian {
+		_, err := s.w.Write(mem)
+		return err
+	}
+	swapUnits(s.w.extend(len(mem)), mem, unit)
+	return nil
+}
+
+// writeFixedElements encodes the fixed-size elements of v one by one through reflection.
+// It handles elements whose memory does not match the wire format, and normalizes floats in canonical mode.
+func (s *Packer) writeFixedElements(v reflect.Value) error {
+	elemKind := v.Type().Elem().Kind()
+	size := s.wireSize(elemKind)
+	dst := s.w.extend(v.Len() * size)
+	for i := 0; i < v.Len(); i++ {
+		s.putFixed(dst[i*size:(i+1)*size], v.Index(i), elemKind)
+	}
+	return nil
+}
+
+// readFixed decodes the fixed-size elements of slice or addressable array v.
+// Elements whose memory matches the wire format are read straight into v and byte-swapped in place on little-endian machines.
+func (s *Packer) readFixed(buf BPReader, v reflect.Value) error {
+	elemType := v.Type().Elem()
+	unit := s.bulkUnit(elemType)
+	mem, ok := elementMemory(v)
+	if unit == 0 || !ok {
+		return s.readFixedElements(buf, v)
+	}
+	_, err := io.ReadFull(buf, mem)
+	if err != nil {
+		return err
+	}
+	if unit > 1 && !hostBigEndian {
+		swapUnits(mem, mem, unit)
+	}
+	if elemType.Kind() == reflect.Bool {
+		// any non-zero byte decodes to true, but a bool in memory must be exactly 0 or 1
+		for i, b := range mem {
+			if b > 1 {
+				mem[i] = 1
+			}
+		}
+	}
+	return nil
+}
+
+// readFixedElements decodes the fixed-size elements of v one by one through reflection, in chunks
+func (s *Packer) readFixedElements(buf BPReader, v reflect.Value) error {
+	elemKind := v.Type().Elem().Kind()
+	size := s.wireSize(elemKind)
+	chunk := s.scratchBuffer()
+	perChunk := len(chunk) / size
+	for start := 0; start < v.Len(); start += perChunk {
+		n := v.Len() - start
+		if n > perChunk {
+			n = perChunk
+		}
+		_, err := io.ReadFull(buf, chunk[:n*size])
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			setFixed(v.Index(start+i), elemKind, chunk[i*size:(i+1)*size])
+		}
+	}
+	return nil
+}
+
+// swapUnits copies src to dst reversing the bytes of every unit-sized number, converting between
+// little-endian memory and the big-endian wire format. dst and src may be the same slice.
+func swapUnits(dst, src []byte, unit int) {
+	switch unit {
+	case 2:
+		for i := 0; i+2 <= len(src); i += 2 {
+			binary.BigEndian.PutUint16(dst[i:], binary.LittleEndian.Uint16(src[i:]))
+		}
+	case 4:
+		for i := 0; i+4 <= len(src); i += 4 {
+			binary.BigEndian.PutUint32(dst[i:], binary.LittleEndian.Uint32(src[i:]))
+		}
+	case 8:
+		for i := 0; i+8 <= len(src); i += 8 {
+			binary.BigEndian.PutUint64(dst[i:], binary.LittleEndian.Uint64(src[i:]))
+		}
+	default:
+		copy(dst, src)
+	}
+}
+
+// putFixed writes the big-endian encoding of v of a fixed-size kind into b
+func (s *Packer) putFixed(b []byte, v reflect.Value, k reflect.Kind) {
+	switch k {
+	case reflect.Bool:
+		b[0] = 0
+		if v.Bool() {
+			b[0] = 1
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		putUint(b, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		putUint(b, v.Uint())
+	case reflect.Float32:
+		binary.BigEndian.PutUint32(b, s.float32bits(float32(v.Float())))
+	case reflect.Float64:
+		binary.BigEndian.PutUint64(b, s.float64bits(v.Float()))
+	case reflect.Complex64:
+		c := v.Complex()
+		binary.BigEndian.PutUint32(b, s.float32bits(float32(real(c))))
+		binary.BigEndian.PutUint32(b[4:], s.float32bits(float32(imag(c))))
+	case reflect.Complex128:
+		c := v.Complex()
+		binary.BigEndian.PutUint64(b, s.float64bits(real(c)))
+		binary.BigEndian.PutUint64(b[8:], s.float64bits(imag(c)))
+	}
+}
+
+// putUint writes the low len(b) bytes of u into b in big-endian order
+func putUint(b []byte, u uint64) {
+	switch len(b) {
+	case 1:
+		b[0] = byte(u)
+	case 2:
+		binary.BigEndian.PutUint16(b, uint16(u))
+	case 4:
+		binary.BigEndian.PutUint32(b, uint32(u))
+	default:
+		binary.BigEndian.PutUint64(b, u)
+	}
+}
+
+func (s *Packer) float32bits(f float32) uint32 {
+	if s.canonical {
+		return canonicalFloat32bits(f)
+	}
+	return math.Float32bits(f)
+}
+
+func (s *Packer) float64bits(f float64) uint64 {
+	if s.canonical {
+		return canonicalFloat64bits(f)
+	}
+	return math.Float64bits(f)
+}
+
+// setFixed sets v of a fixed-size kind from its big-endian encoding b
+func setFixed(v reflect.Value, k reflect.Kind, b []byte) {
+	switch k {
+	case reflect.Bool:
+		v.SetBool(b[0] != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch len(b) {
+		case 1:
+			v.SetInt(int64(int8(b[0])))
+		case 2:
+			v.SetInt(int64(int16(binary.BigEndian.Uint16(b))))
+		case 4:
+			v.SetInt(int64(int32(binary.BigEndian.Uint32(b))))
+		default:
+			v.SetInt(int64(binary.BigEndian.Uint64(b)))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch len(b) {
+		case 1:
+			v.SetUint(uint64(b[0]))
+		case 2:
+			v.SetUint(uint64(binary.BigEndian.Uint16(b)))
+		case 4:
+			v.SetUint(uint64(binary.BigEndian.Uint32(b)))
+		default:
+			v.SetUint(binary.BigEndian.Uint64(b))
+		}
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(b))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case reflect.Complex64:
+		re := math.Float32frombits(binary.BigEndian.Uint32(b))
+		im := math.Float32frombits(binary.BigEndian.Uint32(b[4:]))
+		v.SetComplex(complex(float64(re), float64(im)))
+	case reflect.Complex128:
+		re := math.Float64frombits(binary.BigEndian.Uint64(b))
+		im := math.Float64frombits(binary.BigEndian.Uint64(b[8:]))
+		v.SetComplex(complex(re, im))
+	}
+}
+
+// readWord reads the next n bytes of a fixed-size value, with a single read instead of one ReadByte call per byte
+func (s *Packer) readWord(buf BPReader, n int) ([]byte, error) {
+	if r, ok := buf.(*sliceReader); ok {
+		return r.next(n)
+	}
+	b := s.word[:n]
+	_, err := io.ReadFull(buf, b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,11 @@
+//go:build purego
+
+package bytepack
+
+import "reflect"
+
+// elementMemory never shares the memory of v when built with the purego tag,
+// so fixed-size elements are always converted one by one through reflection.
+func elementMemory(v reflect.Value) ([]byte, bool) {
+	return nil, false
+}
@@ -0,0 +1,123 @@
+package bytepack
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type level int16
+
+type bulkMsg struct {
+	Bools    []bool
+	Int8s    []int8
+	Int16s   []int16
+	Int32s   []int32
+	Int64s   []int64
+	Ints     []int
+	Uints    []uint
+	Uint16s  []uint16
+	Uint32s  []uint32
+	Uint64s  []uint64
+	Uintptrs []uintptr
+	Float32s []float32
+	Float64s []float64
+	C64s     []complex64
+	C128s    []complex128
+	Levels   []level
+	Window   [5]int32
+	Samples  [3]complex64
+	Flags    [4]bool
+}
+
+func newBulkMsg(n int) bulkMsg {
+	m := bulkMsg{Window: [5]int32{-2, -1, 0, 1, 2}, Samples: [3]complex64{1i, -2 + 3i, 4}, Flags: [4]bool{true, false, false, true}}
+	for i := 0; i < n; i++ {
+		m.Bools = append(m.Bools, i%3 == 0)
+		m.Int8s = append(m.Int8s, int8(i-n/2))
+		m.Int16s = append(m.Int16s, int16(i*-131))
+		m.Int32s = append(m.Int32s, int32(i*-70001))
+		m.Int64s = append(m.Int64s, int64(i)*-5214563454864521)
+		m.Ints = append(m.Ints, i*-1000003)
+		m.Uints = append(m.Uints, uint(i)*1000003)
+		m.Uint16s = append(m.Uint16s, uint16(i*257))
+		m.Uint32s = append(m.Uint32s, uint32(i)*16777619)
+		m.Uint64s = append(m.Uint64s, uint64(i)*1099511628211)
+		m.Uintptrs = append(m.Uintptrs, uintptr(i)<<20)
+		m.Float32s = append(m.Float32s, float32(i)/3)
+		m.Float64s = append(m.Float64s, float64(i)/7)
+		m.C64s = append(m.C64s, complex(float32(i), -float32(i)/2))
+		m.C128s = append(m.C128s, complex(float64(i)/3, float64(i)))
+		m.Levels = append(m.Levels, level(i-n/2))
+	}
+	return m
+}
+
+func TestPacker_BulkSlicesAndArrays(t *testing.T) {
+	// larger than a scratch chunk, so the byte-swapping path converts in several chunks
+	m := newBulkMsg(1500)
+
+	for _, p := range []interface{}{m, &m} {
+		buf, err := NewPacker().Pack(p)
+		assert.NoError(t, err)
+
+		var m2 bulkMsg
+		err = NewPacker().Unpack(buf, &m2)
+		assert.NoError(t, err)
+		assert.Equal(t, m, m2)
+	}
+}
+
+func TestPacker_BulkWireFormat(t *testing.T) {
+	s := NewPacker()
+	err := s.encodeSlice(reflect.ValueOf([]int32{1, -2}))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 2, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xfe}, s.w.Bytes())
+
+	s.w.Reset()
+	err = s.encodeArray(reflect.ValueOf([2]complex64{complex(1, -1), 0}))
+	assert.NoError(t, err)
+	expected := make([]byte, 16)
+	binary.BigEndian.PutUint32(expected, math.Float32bits(1))
+	binary.BigEndian.PutUint32(expected[4:], math.Float32bits(-1))
+	assert.Equal(t, expected, s.w.Bytes())
+}
+
+func TestPacker_BulkBoolsAreNormalized(t *testing.T) {
+	var m struct {
+		Flags [3]bool
+		More  []bool
+	}
+	data := []byte{0, 0, 7, 1, 0, 0, 0, 0, 2, 0xff, 0}
+	err := NewPacker().Unpack(data, &m)
+	assert.NoError(t, err)
+	assert.Equal(t, [3]bool{false, true, true}, m.Flags)
+	assert.Equal(t, []bool{true, false}, m.More)
+	// every decoded bool is a proper true, not just a non-zero byte
+	assert.True(t, m.Flags[1] == m.Flags[2])
+	assert.True(t, m.More[0] == m.Flags[2])
+}
+
+func TestPacker_BulkCanonicalFloats(t *testing.T) {
+	a := struct{ Floats []float64 }{[]float64{math.NaN(), math.Copysign(0, -1), 1}}
+	b := struct{ Floats []float64 }{[]float64{math.Float64frombits(0x7ff8000000000001), 0, 1}}
+
+	s := NewPacker(WithCanonical())
+	bufA, err := s.Pack(a)
+	assert.NoError(t, err)
+	bufB, err := s.Pack(b)
+	assert.NoError(t, err)
+	assert.Equal(t, bufA, bufB)
+}
+
+func TestPacker_BulkUnpackTruncated(t *testing.T) {
+	m := newBulkMsg(10)
+	buf, err := NewPacker().Pack(m)
+	assert.NoError(t, err)
+
+	var m2 bulkMsg
+	err = NewPacker().Unpack(buf[:len(buf)-3], &m2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,24 @@
+//go:build !purego
+
+package bytepack
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// elementMemory returns the memory holding the elements of slice or array v.
+// Arrays that are not addressable, such as fields of a struct passed by value, have no memory to share.
+func elementMemory(v reflect.Value) ([]byte, bool) {
+	size := v.Len() * int(v.Type().Elem().Size())
+	if size == 0 {
+		return nil, true
+	}
+	switch {
+	case v.Kind() == reflect.Slice:
+		return unsafe.Slice((*byte)(unsafe.Pointer(v.Pointer())), size), true
+	case v.CanAddr():
+		return unsafe.Slice((*byte)(unsafe.Pointer(v.UnsafeAddr())), size), true
+	}
+	return nil, false
+}
@@ -1,6 +1,7 @@
 package bytepack
 
 import (
+	"hash"
 	"io"
 	"reflect"
 	"sync"
@@ -21,17 +22,53 @@ func Register(strct interface{}) {
 	}
 }
 
+func registeredType(name string) (reflect.Type, bool) {
+	regLock.RLock()
+	defer regLock.RUnlock()
+	rtype, exists := registeredStructs[name]
+	return rtype, exists
+}
+
 type BytePack struct {
-	pool chan *Packer
+	pool       packerPool
+	packerOpts []PackerOption
+	elastic    bool
+	maxPackers int
+	chunking   *chunking
+
+	compressor          Compressor
+	compressThreshold   int
+	maxDecompressedSize int
+	newChecksum         func() hash.Hash
+	maxMessageSize      int
+	keys                KeyProvider
+}
+
+// BytePackOption configures a BytePack at construction time
+type BytePackOption func(p *BytePack)
+
+// WithPackerOptions applies the given options to every Packer in the BytePack
+func WithPackerOptions(opts ...PackerOption) BytePackOption {
+	return func(p *BytePack) {
+		p.packerOpts = append(p.packerOpts, opts...)
+	}
 }
 
-func NewBytePack(numPackers int) *BytePack {
-	bp := BytePack{
-		pool: make(chan *Packer, numPackers),
+func NewBytePack(numPackers int, opts ...BytePackOption) *BytePack {
+	bp := BytePack{}
+	for _, opt := range opts {
+		opt(&bp)
 	}
 
-	for i := 0; i < numPackers; i++ {
-		bp.pool <- NewPacker()
+	newPacker := func() *Packer {
+		s := NewPacker(bp.packerOpts...)
+		s.chunking = bp.chunking
+		return s
+	}
+	if bp.elastic {
+		bp.pool = newElasticPool(numPackers, bp.maxPackers, newPacker)
+	} else {
+		bp.pool = newFixedPool(numPackers, newPacker)
 	}
 
 	return &bp
@@ -39,40 +76,213 @@ func NewBytePack(numPackers int) *BytePack {
 
 func (p *BytePack) Pack(strct interface{}) ([]byte, error) {
 	// get the packer from the pool
-	s := <-p.pool
+	return p.packWith(p.get(), strct)
+}
+
+// packWith packs strct with packer s taken from the pool, and puts s back
+func (p *BytePack) packWith(s *Packer, strct interface{}) ([]byte, error) {
 	bytes, err := s.Pack(strct)
 	// now put the packer back into the pool
-	p.pool <- s
+	p.put(s)
 	if err != nil {
 		return nil, err
 	}
+	if p.framed() {
+		return p.seal(nil, bytes)
+	}
 	return bytes, nil
 }
 
+// PackBuffer packs strct into a pooled Buffer like Packer.PackBuffer does, and wraps it into an envelope if the BytePack uses them.
+// The Buffer must be released once the message is no longer needed.
+func (p *BytePack) PackBuffer(strct interface{}) (*Buffer, error) {
+	// get the packer from the pool
+	s := p.get()
+	b, err := s.PackBuffer(strct)
+	// now put the packer back into the pool
+	p.put(s)
+	if err != nil || !p.framed() {
+		return b, err
+	}
+	defer b.Release()
+	sealed := getBuffer(b.Len() + envelopeOverhead)
+	sealed.data, err = p.seal(sealed.b.Bytes(), b.Bytes())
+	if err != nil {
+		sealed.Release()
+		return nil, err
+	}
+	return sealed, nil
+}
+
 func (p *BytePack) Unpack(data []byte, strct interface{}) error {
+	if p.framed() {
+		var err error
+		data, err = p.open(data)
+		if err != nil {
+			return err
+		}
+	}
+	return p.unpackPayload(data, strct)
+}
+
+func (p *BytePack) unpackPayload(data []byte, strct interface{}) error {
 	// get the packer from the pool
-	s := <-p.pool
+	return p.unpackPayloadWith(p.get(), data, strct)
+}
+
+// unpackPayloadWith unpacks data with packer s taken from the pool, and puts s back
+func (p *BytePack) unpackPayloadWith(s *Packer, data []byte, strct interface{}) error {
 	err := s.Unpack(data, strct)
 	// now put the packer back into the pool
-	p.pool <- s
-	if err != nil {
-		return err
+	p.put(s)
+	return err
+}
+
+// UnpackFields decodes only the named fields of strct, like Packer.UnpackFields does
+func (p *BytePack) UnpackFields(data []byte, strct interface{}, fields ...string) error {
+	if p.framed() {
+		var err error
+		data, err = p.open(data)
+		if err != nil {
+			return err
+		}
 	}
-	return nil
+	// get the packer from the pool
+	s := p.get()
+	err := s.UnpackFields(data, strct, fields...)
+	// now put the packer back into the pool
+	p.put(s)
+	return err
+}
+
+// UnpackInto decodes data into the value strct points to, reusing its memory like Packer.UnpackInto does
+func (p *BytePack) UnpackInto(data []byte, strct interface{}, policy MergePolicy) error {
+	if p.framed() {
+		var err error
+		data, err = p.open(data)
+		if err != nil {
+			return err
+		}
+	}
+	// get the packer from the pool
+	s := p.get()
+	err := s.UnpackInto(data, strct, policy)
+	// now put the packer back into the pool
+	p.put(s)
+	return err
+}
+
+// Skip reads past one message packed from a value of type t, like Packer.Skip does.
+// When the BytePack uses envelopes, the whole envelope is read and its checksum is verified.
+func (p *BytePack) Skip(reader BPReader, t reflect.Type) error {
+	if p.framed() {
+		data, err := p.readEnvelope(reader)
+		if err != nil {
+			return err
+		}
+		return p.validatePayload(data, t)
+	}
+	// get the packer from the pool
+	s := p.get()
+	err := s.Skip(reader, t)
+	// now put the packer back into the pool
+	p.put(s)
+	return err
+}
+
+// Validate checks that data holds exactly one well-formed message packed from a value of type t, like Packer.Validate does
+func (p *BytePack) Validate(data []byte, t reflect.Type) error {
+	if p.framed() {
+		var err error
+		data, err = p.open(data)
+		if err != nil {
+			return err
+		}
+	}
+	return p.validatePayload(data, t)
+}
+
+func (p *BytePack) validatePayload(data []byte, t reflect.Type) error {
+	// get the packer from the pool
+	s := p.get()
+	err := s.Validate(data, t)
+	// now put the packer back into the pool
+	p.put(s)
+	return err
 }
 
 func (p *BytePack) UnpackFromReader(reader BPReader, strct interface{}) error {
+	if p.framed() {
+		data, err := p.readEnvelope(reader)
+		if err != nil {
+			return err
+		}
+		return p.unpackPayload(data, strct)
+	}
 	// get the packer from the pool
-	s := <-p.pool
+	s := p.get()
 	err := s.UnpackFromReader(reader, strct)
 	// now put the packer back into the pool
-	p.pool <- s
+	p.put(s)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// SignedPack packs and signs strct like Packer.SignedPack does, and wraps it into an envelope if the BytePack uses them
+func (p *BytePack) SignedPack(strct interface{}, key SigningKey) ([]byte, error) {
+	// get the packer from the pool
+	s := p.get()
+	bytes, err := s.SignedPack(strct, key)
+	// now put the packer back into the pool
+	p.put(s)
+	if err != nil {
+		return nil, err
+	}
+	if p.framed() {
+		return p.seal(nil, bytes)
+	}
+	return bytes, nil
+}
+
+// VerifyUnpack checks the signature of a message from SignedPack, unpacks it into strct, and returns the signer ID
+func (p *BytePack) VerifyUnpack(data []byte, lookup PublicKeyLookup, strct interface{}) (string, error) {
+	if p.framed() {
+		var err error
+		data, err = p.open(data)
+		if err != nil {
+			return "", err
+		}
+	}
+	return p.verifyUnpackPayload(data, lookup, strct)
+}
+
+func (p *BytePack) verifyUnpackPayload(data []byte, lookup PublicKeyLookup, strct interface{}) (string, error) {
+	// get the packer from the pool
+	s := p.get()
+	signerID, err := s.VerifyUnpack(data, lookup, strct)
+	// now put the packer back into the pool
+	p.put(s)
+	return signerID, err
+}
+
+func (p *BytePack) VerifyUnpackFromReader(reader BPReader, lookup PublicKeyLookup, strct interface{}) (string, error) {
+	if p.framed() {
+		data, err := p.readEnvelope(reader)
+		if err != nil {
+			return "", err
+		}
+		return p.verifyUnpackPayload(data, lookup, strct)
+	}
+	// get the packer from the pool
+	s := p.get()
+	signerID, err := s.VerifyUnpackFromReader(reader, lookup, strct)
+	// now put the packer back into the pool
+	p.put(s)
+	return signerID, err
+}
+
 type bytePackReader struct {
 	io.Reader
 }
@@ -88,14 +298,6 @@ func (r bytePackReader) ReadByte() (byte, error) {
 }
 
 func (p *BytePack) UnpackFromIOReader(reader io.Reader, strct interface{}) error {
-	// get the packer from the pool
-	s := <-p.pool
 	bpr := bytePackReader{reader}
-	err := s.UnpackFromReader(bpr, strct)
-	// now put the packer back into the pool
-	p.pool <- s
-	if err != nil {
-		return err
-	}
-	return nil
+	return p.UnpackFromReader(bpr, strct)
 }
@@ -0,0 +1,158 @@
+package bytepack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// WithCanonical
+/*
+   WithCanonical makes the Packer produce exactly one byte form for every value:
+   map entries are sorted by the encoded bytes of their keys, NaNs and negative zeros are normalized,
+   int and uint always take 8 bytes, and structs are always encoded as the root pointer.
+   Canonical payloads unpack with any Packer on 64-bit platforms, and with a canonical Packer everywhere.
+   Types implementing Packable are encoded by their own Pack method and are only as canonical as that method.
+*/
+func WithCanonical() PackerOption {
+	return func(s *Packer) {
+		s.canonical = true
+	}
+}
+
+// intWidth is the number of bytes int and uint take in the encoding
+func (s *Packer) intWidth() int {
+	if s.canonical {
+		return 8
+	}
+	return intSize
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64 || k == reflect.Complex64 || k == reflect.Complex128
+}
+
+func canonicalFloat64bits(fval float64) uint64 {
+	if math.IsNaN(fval) {
+		return 0x7FF8000000000001
+	}
+	if fval == 0 {
+		return 0
+	}
+	return math.Float64bits(fval)
+}
+
+func canonicalFloat32bits(fval float32) uint32 {
+	if fval != fval {
+		return 0x7FC00001
+	}
+	if fval == 0 {
+		return 0
+	}
+	return math.Float32bits(fval)
+}
+
+// sortedEntries holds the entries of a map ordered by the encoded bytes of their keys
+type sortedEntries struct {
+	encodedKeys []byte
+	// bounds[i] is where the encoded key of entry i starts in encodedKeys
+	bounds []int
+	order  []int
+	values []reflect.Value
+}
+
+func (e *sortedEntries) keyBytes(i int) []byte {
+	return e.encodedKeys[e.bounds[i]:e.bounds[i+1]]
+}
+
+// sortMapEntries orders the entries of map m by the encoded bytes of their keys
+func (s *Packer) sortMapEntries(m reflect.Value) (*sortedEntries, error) {
+	keyType := m.Type().Key()
+	if typeMayHavePointers(keyType, make(map[reflect.Type]bool)) {
+		return nil, errors.New(fmt.Sprintf("map keys of type %v have no canonical encoding", keyType))
+	}
+
+	// collect the values along with the keys, since NaN keys cannot be looked up.
+	// NaNs are normalized, so a NaN key sorts like any other, and several NaN keys are rejected as duplicates.
+	keys := make([]reflect.Value, 0, m.Len())
+	values := make([]reflect.Value, 0, m.Len())
+	iter := m.MapRange()
+	for iter.Next() {
+		keys = append(keys, iter.Key())
+		values = append(values, iter.Value())
+	}
+	bounds := make([]int, len(keys)+1)
+	out := s.w
+	scratch := new(encBuffer)
+	s.w = scratch
+	for i, key := range keys {
+		err := s.encodeValue(key)
+		if err != nil {
+			s.w = out
+			return nil, err
+		}
+		bounds[i+1] = scratch.Len()
+	}
+	s.w = out
+
+	e := &sortedEntries{encodedKeys: scratch.Bytes(), bounds: bounds, order: make([]int, len(keys)), values: values}
+	for i := range e.order {
+		e.order[i] = i
+	}
+	sort.Slice(e.order, func(i, j int) bool {
+		return bytes.Compare(e.keyBytes(e.order[i]), e.keyBytes(e.order[j])) < 0
+	})
+	for i := 1; i < len(e.order); i++ {
+		if bytes.Equal(e.keyBytes(e.order[i-1]), e.keyBytes(e.order[i])) {
+			return nil, errors.New(fmt.Sprintf("map has several keys with the same canonical encoding %v", keys[e.order[i]]))
+		}
+	}
+	return e, nil
+}
+
+// typeMayHavePointers reports whether encoding a value of type t may involve pointer identities
+func typeMayHavePointers(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array:
+		return typeMayHavePointers(t.Elem(), visiting)
+	case reflect.Map:
+		return typeMayHavePointers(t.Key(), visiting) || typeMayHavePointers(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if typeMayHavePointers(t.Field(i).Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Canonicalize
+/*
+   Canonicalize unpacks data as a value of type t and packs it again in the canonical form described in WithCanonical.
+*/
+func (s *Packer) Canonicalize(data []byte, t reflect.Type) ([]byte, error) {
+	v := reflect.New(t)
+	err := s.Unpack(data, v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	canonical := s.canonical
+	s.canonical = true
+	defer func() {
+		s.canonical = canonical
+	}()
+	if t.Kind() == reflect.Struct {
+		return s.Pack(v.Interface())
+	}
+	return s.Pack(v.Elem().Interface())
+}
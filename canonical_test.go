@@ -0,0 +1,142 @@
+package bytepack
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+type canonicalFoo struct {
+	Name   string
+	Scores map[string]int
+	Nested map[int32]map[string]float64
+	Floats []float64
+	Pair   [2]complex128
+}
+
+func newCanonicalFoo() canonicalFoo {
+	f := canonicalFoo{
+		Name:   "canonical",
+		Scores: make(map[string]int),
+		Nested: map[int32]map[string]float64{1: {"a": 1.5, "b": 2.5}, 2: {"c": 3.5}, 3: nil},
+		Floats: []float64{1, math.Copysign(0, -1), math.NaN()},
+		Pair:   [2]complex128{complex(math.NaN(), math.Copysign(0, -1)), complex(1, 2)},
+	}
+	for i := 0; i < 100; i++ {
+		f.Scores["key"+strconv.Itoa(i)] = i
+	}
+	return f
+}
+
+func TestPacker_CanonicalIsDeterministic(t *testing.T) {
+	s := NewPacker(WithCanonical())
+	a := newCanonicalFoo()
+
+	first, err := s.Pack(a)
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		buf, err := NewPacker(WithCanonical()).Pack(newCanonicalFoo())
+		assert.NoError(t, err)
+		assert.Equal(t, first, buf)
+	}
+
+	// a struct and a pointer to it have the same canonical form
+	buf, err := s.Pack(&a)
+	assert.NoError(t, err)
+	assert.Equal(t, first, buf)
+
+	var a2 canonicalFoo
+	err = NewPacker().Unpack(first, &a2)
+	assert.NoError(t, err)
+	assert.Equal(t, a.Name, a2.Name)
+	assert.Equal(t, a.Scores, a2.Scores)
+	assert.Equal(t, a.Nested, a2.Nested)
+	assert.Equal(t, 3, len(a2.Floats))
+	assert.True(t, math.IsNaN(a2.Floats[2]))
+}
+
+func TestPacker_CanonicalNormalizesFloats(t *testing.T) {
+	s := NewPacker(WithCanonical())
+
+	negZero, err := s.Pack(math.Copysign(0, -1))
+	assert.NoError(t, err)
+	zero, err := s.Pack(float64(0))
+	assert.NoError(t, err)
+	assert.Equal(t, zero, negZero)
+
+	nan1, err := s.Pack([]float32{float32(math.NaN())})
+	assert.NoError(t, err)
+	nan2, err := s.Pack([]float32{math.Float32frombits(0x7FC12345)})
+	assert.NoError(t, err)
+	assert.Equal(t, nan1, nan2)
+}
+
+func TestPacker_Canonicalize(t *testing.T) {
+	a := newCanonicalFoo()
+	s := NewPacker()
+	buf, err := s.Pack(a)
+	assert.NoError(t, err)
+
+	canonical, err := s.Canonicalize(buf, reflect.TypeOf(a))
+	assert.NoError(t, err)
+	expected, err := NewPacker(WithCanonical()).Pack(a)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, canonical)
+
+	// the packer keeps its own mode after canonicalizing
+	assert.False(t, s.canonical)
+
+	m := map[uint16]string{1: "a", 2: "b", 3: "c"}
+	buf, err = s.Pack(m)
+	assert.NoError(t, err)
+	canonical, err = s.Canonicalize(buf, reflect.TypeOf(m))
+	assert.NoError(t, err)
+	expected, err = NewPacker(WithCanonical()).Pack(m)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, canonical)
+}
+
+func TestPacker_CanonicalRejectsPointerKeys(t *testing.T) {
+	s := NewPacker(WithCanonical())
+	p := &person{Name: "Tester"}
+	type fooPtrKeys struct {
+		M map[*person]int
+	}
+
+	_, err := s.Pack(fooPtrKeys{M: map[*person]int{p: 1}})
+	assert.Error(t, err)
+}
+
+func TestPacker_CanonicalNaNKeys(t *testing.T) {
+	s := NewPacker(WithCanonical())
+	m := map[float64]int{math.NaN(): 1, 2: 3, -1: 5}
+	packed, err := s.Pack(m)
+	assert.NoError(t, err)
+
+	sum, err := Sum256(m)
+	assert.NoError(t, err)
+	// a NaN key sorts by its normalized encoding, whatever its bits
+	sum2, err := Sum256(map[float64]int{math.Float64frombits(0x7FF8000000001234): 1, 2: 3, -1: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, sum, sum2)
+
+	var m2 map[float64]int
+	assert.NoError(t, NewPacker().Unpack(packed, &m2))
+	assert.Len(t, m2, 3)
+	assert.Equal(t, 3, m2[2])
+	for k, v := range m2 {
+		if math.IsNaN(k) {
+			assert.Equal(t, 1, v)
+		}
+	}
+
+	// several NaN keys have the same canonical encoding
+	_, err = s.Pack(map[float64]int{math.NaN(): 1, math.NaN(): 2})
+	assert.Error(t, err)
+	_, err = Sum256(map[complex128]int{complex(math.NaN(), 0): 1, complex(0, math.NaN()): 2, 1: 3})
+	assert.NoError(t, err)
+	_, err = Sum256(map[complex64]int{complex(float32(math.NaN()), 0): 1, complex(float32(math.NaN()), 0): 2})
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+package bytepack
+
+import (
+	"hash"
+	"hash/crc32"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// WithChecksum
+/*
+   WithChecksum appends a checksum computed by newHash to every packed message. Unpack verifies it before decoding
+   and returns ErrChecksumMismatch for corrupted messages. Both sides must be configured with the same checksum.
+*/
+func WithChecksum(newHash func() hash.Hash) BytePackOption {
+	return func(p *BytePack) {
+		p.newChecksum = newHash
+	}
+}
+
+// WithCRC32C appends a CRC-32 checksum with the Castagnoli polynomial to every packed message
+func WithCRC32C() BytePackOption {
+	return WithChecksum(func() hash.Hash {
+		return crc32.New(crc32cTable)
+	})
+}
+
+// WithMaxMessageSize
+/*
+   WithMaxMessageSize limits how many bytes of a message body UnpackFromReader is willing to read,
+   so that a corrupted length cannot make it consume an unbounded amount of the stream.
+   Unless WithMaxDecompressedSize is given, it also limits how large a compressed message may grow when decompressed.
+*/
+func WithMaxMessageSize(maxBytes int) BytePackOption {
+	return func(p *BytePack) {
+		p.maxMessageSize = maxBytes
+	}
+}
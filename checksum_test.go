@@ -0,0 +1,87 @@
+package bytepack
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBytePack_Checksum(t *testing.T) {
+	bp := NewBytePack(1, WithCRC32C())
+	m := newSnapshotMsg(10)
+
+	packed, err := bp.Pack(m)
+	assert.NoError(t, err)
+	assert.Equal(t, envelopeChecksummed, packed[0])
+
+	var m2 snapshotMsg
+	err = bp.Unpack(packed, &m2)
+	assert.NoError(t, err)
+	assert.Equal(t, m, m2)
+
+	var m3 snapshotMsg
+	err = bp.UnpackFromReader(bytes.NewBuffer(packed), &m3)
+	assert.NoError(t, err)
+	assert.Equal(t, m, m3)
+}
+
+func TestBytePack_ChecksumDetectsBitFlips(t *testing.T) {
+	bps := []*BytePack{
+		NewBytePack(1, WithCRC32C()),
+		NewBytePack(1, WithChecksum(sha256.New), WithCompression(NewFlateCompressor(flate.DefaultCompression), 0)),
+	}
+	for _, bp := range bps {
+		packed, err := bp.Pack(newSnapshotMsg(10))
+		assert.NoError(t, err)
+
+		for i := 0; i < len(packed); i++ {
+			for bit := 0; bit < 8; bit += 3 {
+				corrupted := make([]byte, len(packed))
+				copy(corrupted, packed)
+				corrupted[i] ^= 1 << bit
+
+				var m snapshotMsg
+				err = bp.Unpack(corrupted, &m)
+				assert.Error(t, err)
+				if i != 0 {
+					// a flipped flag is caught as an unknown flag
+					assert.ErrorIs(t, err, ErrChecksumMismatch)
+				}
+			}
+		}
+	}
+}
+
+func TestBytePack_ChecksumFromReaderWithCorruptedLength(t *testing.T) {
+	bp := NewBytePack(1, WithCRC32C(), WithMaxMessageSize(1<<20))
+	packed, err := bp.Pack(newSnapshotMsg(10))
+	assert.NoError(t, err)
+
+	// the body length gets a huge value, the message is rejected without reading the stream
+	corrupted := make([]byte, len(packed))
+	copy(corrupted, packed)
+	corrupted[1] ^= 0x80
+	reader := bytes.NewBuffer(corrupted)
+	var m snapshotMsg
+	err = bp.UnpackFromReader(reader, &m)
+	assert.Error(t, err)
+	assert.Equal(t, len(packed)-5, reader.Len())
+
+	// a checksum mismatch is reported for a flipped body bit
+	copy(corrupted, packed)
+	corrupted[10] ^= 0x01
+	err = bp.UnpackFromReader(bytes.NewBuffer(corrupted), &m)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestBytePack_ChecksumRequired(t *testing.T) {
+	plain := NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 1<<20))
+	packed, err := plain.Pack(newSnapshotMsg(10))
+	assert.NoError(t, err)
+
+	var m snapshotMsg
+	err = NewBytePack(1, WithCRC32C()).Unpack(packed, &m)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
@@ -0,0 +1,418 @@
+package bytepack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// the flag in front of a slice or a map
+const (
+	notNilFlag  = 0
+	nilFlag     = 1
+	chunkedFlag = 2
+)
+
+const (
+	defaultChunkMinLen = 16384
+	defaultChunkLen    = 4096
+)
+
+// chunking configures the packers of a BytePack that encodes large slices and maps in parallel chunks
+type chunking struct {
+	minLen   int
+	chunkLen int
+	// workers is the BytePack whose packers encode and decode the chunks
+	workers *BytePack
+}
+
+// WithParallelChunks
+/*
+   WithParallelChunks makes the BytePack split slices and maps of at least minLen elements into chunks of chunkLen
+   elements, which are encoded concurrently on the packers of the BytePack, or on temporary ones when all are busy.
+   The chunks are preceded by an index of their sizes, so the decoder of such a BytePack unpacks them in parallel too.
+   Any other packer still reads chunked messages, one element after another.
+   Only collections whose elements hold no pointers or interfaces, and no values of their own type, are chunked,
+   since pointer identities cannot be shared between chunks, and decoders reject chunked collections of any other type.
+   Slices of fixed-size numbers are never chunked, they are copied in bulk anyway.
+   Canonical packers never chunk. minLen and chunkLen of 0 or less select defaults of 16384 and 4096.
+*/
+func WithParallelChunks(minLen int, chunkLen int) BytePackOption {
+	return func(p *BytePack) {
+		if minLen <= 0 {
+			minLen = defaultChunkMinLen
+		}
+		if chunkLen <= 0 {
+			chunkLen = defaultChunkLen
+		}
+		p.chunking = &chunking{minLen: minLen, chunkLen: chunkLen, workers: p}
+	}
+}
+
+// chunkSpan describes one chunk of a chunked slice or map
+type chunkSpan struct {
+	// count is the number of elements, or map entries, in the chunk
+	count int
+	// size is the length of the encoded chunk in bytes
+	size int
+}
+
+// chunkCount returns the number of chunks slice or map v is encoded in, or 0 if v is not chunked
+func (s *Packer) chunkCount(v reflect.Value) int {
+	if s.chunking == nil || s.canonical || v.Len() < s.chunking.minLen || v.Len() <= s.chunking.chunkLen {
+		return 0
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Slice && s.wireSize(t.Elem().Kind()) > 0 || !chunkable(t) {
+		return 0
+	}
+	return (v.Len() + s.chunking.chunkLen - 1) / s.chunking.chunkLen
+}
+
+// chunkable reports whether slices or maps of type t may be chunked: pointer identities cannot be shared between
+// chunks, and the elements of chunks are traversed apart, which needs them to nest no deeper than their type
+func chunkable(t reflect.Type) bool {
+	visiting := make(map[reflect.Type]bool)
+	if typeMayHavePointers(t.Elem(), visiting) || t.Kind() == reflect.Map && typeMayHavePointers(t.Key(), visiting) {
+		return false
+	}
+	return !typeMayRecurse(t, make(map[reflect.Type]bool))
+}
+
+// typeMayRecurse reports whether a value of type t may hold a value of its own type, or of any type in path
+func typeMayRecurse(t reflect.Type, path map[reflect.Type]bool) bool {
+	if path[t] {
+		return true
+	}
+	path[t] = true
+	defer delete(path, t)
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Ptr:
+		return typeMayRecurse(t.Elem(), path)
+	case reflect.Map:
+		return typeMayRecurse(t.Key(), path) || typeMayRecurse(t.Elem(), path)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if typeMayRecurse(t.Field(i).Type, path) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// chunkHeaderSize is the size of the header and index of a collection in numChunks chunks
+func chunkHeaderSize(numChunks int) int {
+	return 1 + 4 + 4 + numChunks*(4+4)
+}
+
+// encodeChunked encodes the elements of slice v, or the entries of map v, in numChunks chunks encoded in parallel
+func (s *Packer) encodeChunked(v reflect.Value, numChunks int) error {
+	// map entries are collected with their values, since keys such as NaN cannot be looked up
+	var keys, values []reflect.Value
+	if v.Kind() == reflect.Map {
+		keys = make([]reflect.Value, 0, v.Len())
+		values = make([]reflect.Value, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			keys = append(keys, iter.Key())
+			values = append(values, iter.Value())
+		}
+	}
+	chunkLen := s.chunking.chunkLen
+	chunks := make([][]byte, numChunks)
+	err := s.runChunks(numChunks, func(w *Packer, i int) error {
+		from, to := i*chunkLen, (i+1)*chunkLen
+		if to > v.Len() {
+			to = v.Len()
+		}
+		data, err := w.encodeScoped(func() error {
+			for j := from; j < to; j++ {
+				var err error
+				if keys != nil {
+					err = w.encodeValue(keys[j])
+					if err == nil {
+						err = w.encodeValue(values[j])
+					}
+				} else {
+					err = w.encodeValue(v.Index(j))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		chunks[i] = data
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	header := s.w.extend(chunkHeaderSize(numChunks))
+	header[0] = chunkedFlag
+	putUint(header[1:5], uint64(v.Len()))
+	putUint(header[5:9], uint64(numChunks))
+	for i, data := range chunks {
+		count := chunkLen
+		if i == numChunks-1 {
+			count = v.Len() - i*chunkLen
+		}
+		putUint(header[9+8*i:13+8*i], uint64(count))
+		putUint(header[13+8*i:17+8*i], uint64(len(data)))
+	}
+	for _, data := range chunks {
+		_, err = s.w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runChunks runs work for every chunk, on as many goroutines as there are processors. Each goroutine works with a
+// packer of the BytePack, or with one of its own when none is free, so chunks never wait for busy packers.
+func (s *Packer) runChunks(numChunks int, work func(w *Packer, i int) error) error {
+	// the collection is a nesting level, but has no frame since its elements are traversed by the workers
+	err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer s.leave()
+	workers := runtime.GOMAXPROCS(0)
+	if workers > numChunks {
+		workers = numChunks
+	}
+	var next atomic.Int64
+	var errOnce sync.Once
+	var firstErr error
+	var panicOnce sync.Once
+	var panicked interface{}
+	var wg sync.WaitGroup
+	for g := 0; g < workers; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// pass panics on to the caller, where they can be recovered
+			defer func() {
+				if r := recover(); r != nil {
+					panicOnce.Do(func() {
+						panicked = r
+					})
+					next.Store(int64(numChunks))
+				}
+			}()
+			w, pooled := s.chunking.workers.pool.tryGet()
+			if pooled {
+				defer func() {
+					// the packer goes back to the pool at the top level, like any other
+					w.depth, w.nested, w.stackBase = 0, 0, 0
+					s.chunking.workers.put(w)
+				}()
+			} else {
+				w = s.derive()
+			}
+			// chunks hold no pointers, but a packer from the pool may still hold those of an earlier message
+			w.ptrIdCounter = 1
+			w.ptrstoid = make(map[uintptr]uint32)
+			w.idstoptr = make(map[uint32]*decodingPtr)
+			w.claimedPtrs = nil
+			// the elements nest as deep as the collection, but the worker starts on a fresh stack
+			w.depth, w.nested, w.stackBase = s.depth, 0, 0
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= numChunks {
+					return
+				}
+				err := work(w, i)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+					})
+					// make the other goroutines stop too
+					next.Store(int64(numChunks))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+	return firstErr
+}
+
+// readCollectionHeader reads the flag and the length in front of a slice or a map. A chunked collection also has
+// an index of its chunks, returned in chunks. Its elements follow the index back to back, so they can be read
+// one after another like those of a collection that was not chunked.
+func (s *Packer) readCollectionHeader(buf BPReader, t reflect.Type) (n int, isNil bool, chunks []chunkSpan, err error) {
+	kind := t.Kind()
+	flag, err := s.UnpackUint8(buf)
+	if err != nil {
+		return 0, false, nil, err
+	}
+	if flag == nilFlag {
+		return 0, true, nil, nil
+	}
+	if flag != notNilFlag && flag != chunkedFlag {
+		return 0, false, nil, errors.New(fmt.Sprintf("invalid %v flag %d", kind, flag))
+	}
+	length, err := s.UnpackInt32(buf)
+	if err != nil {
+		return 0, false, nil, err
+	}
+	if length < 0 {
+		return 0, false, nil, errors.New(fmt.Sprintf("invalid %v length %d", kind, length))
+	}
+	if flag == notNilFlag {
+		return int(length), false, nil, nil
+	}
+	if !chunkable(t) {
+		return 0, false, nil, errors.New(fmt.Sprintf("%v cannot be chunked, its elements may hold pointers or nest without bound", t))
+	}
+
+	numChunks, err := s.UnpackInt32(buf)
+	if err != nil {
+		return 0, false, nil, err
+	}
+	if numChunks < 0 || numChunks > length {
+		return 0, false, nil, errors.New(fmt.Sprintf("invalid number of chunks %d for %d elements", numChunks, length))
+	}
+	total := 0
+	for i := 0; i < int(numChunks); i++ {
+		count, err := s.UnpackInt32(buf)
+		if err != nil {
+			return 0, false, nil, err
+		}
+		size, err := s.UnpackInt32(buf)
+		if err != nil {
+			return 0, false, nil, err
+		}
+		if count < 0 || size < 0 {
+			return 0, false, nil, errors.New(fmt.Sprintf("invalid chunk of %d elements in %d bytes", count, size))
+		}
+		total += int(count)
+		chunks = append(chunks, chunkSpan{count: int(count), size: int(size)})
+	}
+	if total != int(length) {
+		return 0, false, nil, errors.New(fmt.Sprintf("chunks hold %d elements instead of %d", total, length))
+	}
+	return int(length), false, chunks, nil
+}
+
+// parallelChunks reports whether s decodes chunks in parallel
+func (s *Packer) parallelChunks(chunks []chunkSpan) bool {
+	return s.chunking != nil && len(chunks) > 1
+}
+
+// readChunkData returns the bytes of all chunks, without copying them when buf is in memory
+func readChunkData(buf BPReader, chunks []chunkSpan) ([]byte, error) {
+	total := 0
+	for _, c := range chunks {
+		total += c.size
+	}
+	if r, ok := buf.(*sliceReader); ok {
+		data, err := r.next(total)
+		return data, unexpectedEOF(err)
+	}
+	// copy instead of allocating upfront, so a corrupted index cannot make us allocate a huge buffer
+	var data bytes.Buffer
+	_, err := io.CopyN(&data, buf, int64(total))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return data.Bytes(), nil
+}
+
+// readChunks decodes chunks in parallel. read decodes the element, or map entry, at index i from buf.
+func (s *Packer) readChunks(buf BPReader, chunks []chunkSpan, read func(w *Packer, buf BPReader, i int) error) error {
+	data, err := readChunkData(buf, chunks)
+	if err != nil {
+		return err
+	}
+	firstElem := make([]int, len(chunks))
+	offsets := make([]int, len(chunks))
+	for i := 1; i < len(chunks); i++ {
+		firstElem[i] = firstElem[i-1] + chunks[i-1].count
+		offsets[i] = offsets[i-1] + chunks[i-1].size
+	}
+	return s.runChunks(len(chunks), func(w *Packer, i int) error {
+		r := newSliceReader(data[offsets[i] : offsets[i]+chunks[i].size])
+		for j := firstElem[i]; j < firstElem[i]+chunks[i].count; j++ {
+			err := read(w, r, j)
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+		}
+		if r.Len() > 0 {
+			return errors.New(fmt.Sprintf("%d unexpected bytes after the elements of chunk %d", r.Len(), i))
+		}
+		return nil
+	})
+}
+
+// readChunkedSlice decodes the chunks of a slice of n elements in parallel
+func (s *Packer) readChunkedSlice(sliceType reflect.Type, buf BPReader, n int, chunks []chunkSpan) (*reflect.Value, error) {
+	sliceValue := reflect.MakeSlice(sliceType, n, n)
+	err := s.readChunks(buf, chunks, func(w *Packer, r BPReader, i int) error {
+		return w.readStructField(r, sliceValue.Index(i), fieldInfo{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sliceValue, nil
+}
+
+// readChunkedMapEntries decodes the chunks of n map entries in parallel, and then adds the entries to readMap
+func (s *Packer) readChunkedMapEntries(mapType reflect.Type, buf BPReader, readMap reflect.Value, n int, chunks []chunkSpan) error {
+	keys := reflect.MakeSlice(reflect.SliceOf(mapType.Key()), n, n)
+	values := reflect.MakeSlice(reflect.SliceOf(mapType.Elem()), n, n)
+	err := s.readChunks(buf, chunks, func(w *Packer, r BPReader, i int) error {
+		err := w.readStructField(r, keys.Index(i), fieldInfo{})
+		if err != nil {
+			return err
+		}
+		return w.readStructField(r, values.Index(i), fieldInfo{})
+	})
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		readMap.SetMapIndex(keys.Index(i), values.Index(i))
+	}
+	return nil
+}
+
+// skipChunked skips the elements of chunks with skipElem. When buf is in memory,
+// it also checks that every chunk is as long as the index says.
+func (s *Packer) skipChunked(buf BPReader, chunks []chunkSpan, skipElem func() error) error {
+	err := s.enter()
+	if err != nil {
+		return err
+	}
+	defer s.leave()
+	r, inMemory := buf.(*sliceReader)
+	for i, c := range chunks {
+		start := 0
+		if inMemory {
+			start = r.pos
+		}
+		for j := 0; j < c.count; j++ {
+			err := skipElem()
+			if err != nil {
+				return err
+			}
+		}
+		if inMemory && r.pos-start != c.size {
+			return errors.New(fmt.Sprintf("chunk %d takes %d bytes instead of %d", i, r.pos-start, c.size))
+		}
+	}
+	return nil
+}
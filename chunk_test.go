@@ -0,0 +1,247 @@
+package bytepack
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type census struct {
+	Region  string
+	People  []person
+	Names   []string
+	ByName  map[string]person
+	Counts  []int64
+	Parents []*person
+}
+
+func newCensus(n int) census {
+	c := census{Region: "North", ByName: make(map[string]person), Counts: make([]int64, n)}
+	for i := 0; i < n; i++ {
+		p := person{Name: fmt.Sprintf("Tester %d", i), Age: int32(i % 90), Height: 5 + float64(i%10)/10}
+		c.People = append(c.People, p)
+		c.Names = append(c.Names, p.Name)
+		c.ByName[p.Name] = p
+		c.Counts[i] = int64(i)
+		c.Parents = append(c.Parents, &c.People[i])
+	}
+	return c
+}
+
+func TestBytePack_ParallelChunksRoundTrip(t *testing.T) {
+	bp := NewBytePack(2, WithParallelChunks(10, 7))
+	c := newCensus(100)
+	packed, err := bp.Pack(c)
+	assert.NoError(t, err)
+
+	plain, err := NewPacker().Pack(c)
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, packed)
+
+	var c2 census
+	assert.NoError(t, bp.Unpack(packed, &c2))
+	assert.Equal(t, c, c2)
+
+	// a packer without chunking reads the chunks one element after another
+	var c3 census
+	assert.NoError(t, NewPacker().Unpack(packed, &c3))
+	assert.Equal(t, c, c3)
+
+	// and from a stream too
+	var c4 census
+	assert.NoError(t, bp.UnpackFromReader(bytes.NewBuffer(packed), &c4))
+	assert.Equal(t, c, c4)
+}
+
+func TestBytePack_ParallelChunksOnlyLargeCollections(t *testing.T) {
+	bp := NewBytePack(1, WithParallelChunks(200, 7))
+	c := newCensus(100)
+	// maps are encoded in random order
+	c.ByName = nil
+	packed, err := bp.Pack(c)
+	assert.NoError(t, err)
+	plain, err := NewPacker().Pack(c)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, packed)
+}
+
+func TestBytePack_ParallelChunksSkipPointersAndNumbers(t *testing.T) {
+	bp := NewBytePack(1, WithParallelChunks(10, 7))
+	c := census{Counts: make([]int64, 100)}
+	for i := 0; i < 100; i++ {
+		c.Parents = append(c.Parents, &person{Name: "Parent", Age: int32(i)})
+	}
+	packed, err := bp.Pack(c)
+	assert.NoError(t, err)
+	plain, err := NewPacker().Pack(c)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, packed)
+}
+
+func TestBytePack_ParallelChunksWithBusyPackers(t *testing.T) {
+	bp := NewBytePack(1, WithParallelChunks(10, 7))
+	c := newCensus(50)
+	busy := bp.get()
+	// chunks are encoded on packers of their own, instead of waiting for the busy one
+	packed, err := busy.Pack(c)
+	assert.NoError(t, err)
+	var c2 census
+	assert.NoError(t, busy.Unpack(packed, &c2))
+	assert.Equal(t, c, c2)
+	bp.put(busy)
+	assert.Equal(t, 0, bp.Stats().InUse)
+}
+
+func TestBytePack_ParallelChunksSizeSkipAndValidate(t *testing.T) {
+	bp := NewBytePack(1, WithParallelChunks(10, 7))
+	c := newCensus(60)
+	packed, err := bp.Pack(c)
+	assert.NoError(t, err)
+
+	s := bp.get()
+	size, err := s.Size(c)
+	bp.put(s)
+	assert.NoError(t, err)
+	assert.Equal(t, len(packed), size)
+
+	assert.NoError(t, bp.Validate(packed, reflect.TypeOf(c)))
+	assert.NoError(t, bp.Skip(bytes.NewBuffer(packed), reflect.TypeOf(c)))
+
+	var names census
+	assert.NoError(t, bp.UnpackFields(packed, &names, "Names"))
+	assert.Equal(t, c.Names, names.Names)
+	assert.Nil(t, names.People)
+}
+
+func TestBytePack_ParallelChunksUnpackInto(t *testing.T) {
+	bp := NewBytePack(1, WithParallelChunks(10, 7))
+	c := newCensus(30)
+	packed, err := bp.Pack(c)
+	assert.NoError(t, err)
+
+	into := census{ByName: map[string]person{"Old": {Name: "Old"}}}
+	assert.NoError(t, bp.UnpackInto(packed, &into, MergeUnionMaps))
+	assert.Len(t, into.ByName, 31)
+	assert.Equal(t, c.People, into.People)
+	assert.Equal(t, c.ByName["Tester 7"], into.ByName["Tester 7"])
+}
+
+func TestBytePack_ParallelChunksCorruptedIndex(t *testing.T) {
+	bp := NewBytePack(1, WithParallelChunks(10, 7))
+	type names struct {
+		Names []string
+	}
+	n := names{}
+	for i := 0; i < 20; i++ {
+		n.Names = append(n.Names, "Tester")
+	}
+	packed, err := bp.Pack(n)
+	assert.NoError(t, err)
+	// root flag, chunked flag, 20 elements, 3 chunks
+	assert.Equal(t, []byte{0, chunkedFlag, 0, 0, 0, 20, 0, 0, 0, 3}, packed[:10])
+
+	// the first chunk claims one byte more than it holds
+	corrupted := append([]byte{}, packed...)
+	corrupted[17]++
+	assert.Error(t, bp.Unpack(corrupted, &names{}))
+	assert.Error(t, bp.Validate(corrupted, reflect.TypeOf(n)))
+
+	// the chunks hold fewer elements than the slice
+	corrupted = append([]byte{}, packed...)
+	corrupted[13]--
+	assert.Error(t, bp.Unpack(corrupted, &names{}))
+	assert.Error(t, NewPacker().Unpack(corrupted, &names{}))
+
+	// an unknown flag
+	corrupted = append([]byte{}, packed...)
+	corrupted[1] = 7
+	assert.Error(t, NewPacker().Unpack(corrupted, &names{}))
+}
+
+func TestBytePack_ParallelChunksRejectPointers(t *testing.T) {
+	type parents struct {
+		Parents []*person
+	}
+	p := parents{Parents: []*person{{Name: "A"}, {Name: "B"}}}
+	plain, err := NewPacker().Pack(p)
+	assert.NoError(t, err)
+
+	// rewrite the slice into two chunks of one parent each
+	elems := plain[6:]
+	first, err := NewPacker().Size(parents{Parents: p.Parents[:1]})
+	assert.NoError(t, err)
+	firstLen := first - 6
+	chunked := []byte{0, chunkedFlag, 0, 0, 0, 2, 0, 0, 0, 2}
+	chunked = append(chunked, 0, 0, 0, 1, 0, 0, 0, byte(firstLen))
+	chunked = append(chunked, 0, 0, 0, 1, 0, 0, 0, byte(len(elems)-firstLen))
+	chunked = append(chunked, elems...)
+
+	bp := NewBytePack(1, WithParallelChunks(1, 1))
+	assert.ErrorContains(t, bp.Unpack(chunked, &parents{}), "cannot be chunked")
+	assert.ErrorContains(t, NewPacker().Unpack(chunked, &parents{}), "cannot be chunked")
+	assert.Error(t, bp.Validate(chunked, reflect.TypeOf(p)))
+	assert.Error(t, bp.UnpackInto(chunked, &parents{}, MergeOverwrite))
+
+	// and the original still decodes
+	var p2 parents
+	assert.NoError(t, bp.Unpack(plain, &p2))
+	assert.Equal(t, p, p2)
+}
+
+func TestBytePack_ParallelChunksPassPanicsOn(t *testing.T) {
+	bp := NewBytePack(1, WithParallelChunks(1, 1))
+	s := bp.get()
+	defer bp.put(s)
+	assert.PanicsWithValue(t, "chunk 3", func() {
+		_ = s.runChunks(8, func(w *Packer, i int) error {
+			assert.NotNil(t, w.idstoptr)
+			if i == 3 {
+				panic("chunk 3")
+			}
+			return nil
+		})
+	})
+	assert.Equal(t, 1, bp.Stats().InUse)
+}
+
+func TestBytePack_ParallelChunksNaNKeys(t *testing.T) {
+	bp := NewBytePack(2, WithParallelChunks(4, 2))
+	type scores struct {
+		M map[float64]int
+	}
+	m := scores{M: map[float64]int{math.NaN(): 1, 2: 2, 3: 3, 4: 4, 5: 5}}
+	packed, err := bp.Pack(&m)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(chunkedFlag), packed[3])
+
+	var m2 scores
+	assert.NoError(t, bp.Unpack(packed, &m2))
+	assert.Len(t, m2.M, 5)
+	for k, v := range m2.M {
+		if math.IsNaN(k) {
+			assert.Equal(t, 1, v)
+		} else {
+			assert.Equal(t, m.M[k], v)
+		}
+	}
+}
+
+func TestBytePack_ParallelChunksResetWorkers(t *testing.T) {
+	bp := NewBytePack(2, WithParallelChunks(10, 7))
+	packed, err := bp.Pack(newCensus(100))
+	assert.NoError(t, err)
+	assert.NoError(t, bp.Unpack(packed, &census{}))
+
+	// the workers go back to the pool at the top level
+	s1, s2 := bp.get(), bp.get()
+	for _, w := range []*Packer{s1, s2} {
+		assert.Equal(t, 0, w.depth)
+		assert.Equal(t, 0, w.nested)
+		assert.Equal(t, 0, w.stackBase)
+	}
+	bp.put(s1)
+	bp.put(s2)
+}
@@ -0,0 +1,198 @@
+package bytepack
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compressor
+/*
+   Compressor compresses packed messages in a BytePack. The ID is written into every compressed message,
+   so the receiving BytePack can find the same compressor in the registry. IDs 1 through 3 are taken by the
+   built-in flate, gzip and zlib compressors.
+*/
+type Compressor interface {
+	ID() uint8
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+const (
+	FlateCompressorID uint8 = 1
+	GzipCompressorID  uint8 = 2
+	ZlibCompressorID  uint8 = 3
+)
+
+var registeredCompressors = map[uint8]Compressor{
+	FlateCompressorID: NewFlateCompressor(flate.DefaultCompression),
+	GzipCompressorID:  NewGzipCompressor(gzip.DefaultCompression),
+	ZlibCompressorID:  NewZlibCompressor(zlib.DefaultCompression),
+}
+var compressorsLock sync.RWMutex
+
+// RegisterCompressor makes a custom compressor available for decompressing messages with its ID
+func RegisterCompressor(c Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+	registeredCompressors[c.ID()] = c
+}
+
+func registeredCompressor(id uint8) (Compressor, error) {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+	c, exists := registeredCompressors[id]
+	if !exists {
+		return nil, errors.New(fmt.Sprintf("compressor %d is not registered", id))
+	}
+	return c, nil
+}
+
+// WithCompression
+/*
+   WithCompression compresses packed messages of at least threshold bytes with c.
+   Smaller messages, and messages that do not get any smaller, are left uncompressed.
+*/
+func WithCompression(c Compressor, threshold int) BytePackOption {
+	return func(p *BytePack) {
+		p.compressor = c
+		p.compressThreshold = threshold
+	}
+}
+
+// DefaultMaxDecompressedSize is how large a compressed message may grow when decompressed, unless the BytePack
+// is configured with WithMaxDecompressedSize or WithMaxMessageSize
+const DefaultMaxDecompressedSize = 64 << 20
+
+// WithMaxDecompressedSize
+/*
+   WithMaxDecompressedSize limits how large a compressed message may grow when decompressed, so that a small
+   message crafted to decompress into gigabytes fails instead of exhausting memory. Without it, the limit is
+   the one set with WithMaxMessageSize, or DefaultMaxDecompressedSize.
+*/
+func WithMaxDecompressedSize(maxBytes int) BytePackOption {
+	return func(p *BytePack) {
+		p.maxDecompressedSize = maxBytes
+	}
+}
+
+// decompressedLimit returns how many bytes a compressed message may decompress into
+func (p *BytePack) decompressedLimit() int64 {
+	if p.maxDecompressedSize > 0 {
+		return int64(p.maxDecompressedSize)
+	}
+	if p.maxMessageSize > 0 {
+		return int64(p.maxMessageSize)
+	}
+	return DefaultMaxDecompressedSize
+}
+
+/*-----------------------------------
+  Built-in compressors
+ -----------------------------------*/
+
+// NewFlateCompressor returns a raw DEFLATE compressor with the given compress/flate level
+func NewFlateCompressor(level int) Compressor {
+	return &flateCompressor{level: level}
+}
+
+// NewGzipCompressor returns a gzip compressor with the given compress/gzip level
+func NewGzipCompressor(level int) Compressor {
+	return &gzipCompressor{level: level}
+}
+
+// NewZlibCompressor returns a zlib compressor with the given compress/zlib level
+func NewZlibCompressor(level int) Compressor {
+	return &zlibCompressor{level: level}
+}
+
+// pooledWriter returns the compressing writer to its pool once closed
+type pooledWriter struct {
+	io.WriteCloser
+	pool *sync.Pool
+}
+
+func (w *pooledWriter) Close() error {
+	err := w.WriteCloser.Close()
+	w.pool.Put(w.WriteCloser)
+	return err
+}
+
+type flateCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+func (c *flateCompressor) ID() uint8 {
+	return FlateCompressorID
+}
+
+func (c *flateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if fw, ok := c.writers.Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return &pooledWriter{fw, &c.writers}, nil
+	}
+	fw, err := flate.NewWriter(w, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledWriter{fw, &c.writers}, nil
+}
+
+func (c *flateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+type gzipCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+func (c *gzipCompressor) ID() uint8 {
+	return GzipCompressorID
+}
+
+func (c *gzipCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if gw, ok := c.writers.Get().(*gzip.Writer); ok {
+		gw.Reset(w)
+		return &pooledWriter{gw, &c.writers}, nil
+	}
+	gw, err := gzip.NewWriterLevel(w, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledWriter{gw, &c.writers}, nil
+}
+
+func (c *gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zlibCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+func (c *zlibCompressor) ID() uint8 {
+	return ZlibCompressorID
+}
+
+func (c *zlibCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if zw, ok := c.writers.Get().(*zlib.Writer); ok {
+		zw.Reset(w)
+		return &pooledWriter{zw, &c.writers}, nil
+	}
+	zw, err := zlib.NewWriterLevel(w, c.level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledWriter{zw, &c.writers}, nil
+}
+
+func (c *zlibCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
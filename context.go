@@ -0,0 +1,197 @@
+package bytepack
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrNoPackerAvailable is returned by the Try variants when every packer of the BytePack is busy
+var ErrNoPackerAvailable = errors.New("no packer available")
+
+// acquire takes a packer from the pool, waiting until one is free or ctx is done
+func (p *BytePack) acquire(ctx context.Context) (*Packer, error) {
+	return p.pool.get(ctx)
+}
+
+// tryAcquire takes a packer from the pool only if one is free right away
+func (p *BytePack) tryAcquire() (*Packer, error) {
+	s, ok := p.pool.tryGet()
+	if !ok {
+		return nil, ErrNoPackerAvailable
+	}
+	return s, nil
+}
+
+// PackContext packs strct like Pack does, but gives up with the error of ctx if ctx is done before a packer is free
+func (p *BytePack) PackContext(ctx context.Context, strct interface{}) ([]byte, error) {
+	s, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.packWith(s, strct)
+}
+
+// TryPack packs strct like Pack does, but fails with ErrNoPackerAvailable instead of waiting for a busy packer
+func (p *BytePack) TryPack(strct interface{}) ([]byte, error) {
+	s, err := p.tryAcquire()
+	if err != nil {
+		return nil, err
+	}
+	return p.packWith(s, strct)
+}
+
+// UnpackContext unpacks data like Unpack does, but gives up with the error of ctx if ctx is done before a packer is free
+func (p *BytePack) UnpackContext(ctx context.Context, data []byte, strct interface{}) error {
+	if p.framed() {
+		var err error
+		data, err = p.open(data)
+		if err != nil {
+			return err
+		}
+	}
+	s, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	return p.unpackPayloadWith(s, data, strct)
+}
+
+// TryUnpack unpacks data like Unpack does, but fails with ErrNoPackerAvailable instead of waiting for a busy packer
+func (p *BytePack) TryUnpack(data []byte, strct interface{}) error {
+	if p.framed() {
+		var err error
+		data, err = p.open(data)
+		if err != nil {
+			return err
+		}
+	}
+	s, err := p.tryAcquire()
+	if err != nil {
+		return err
+	}
+	return p.unpackPayloadWith(s, data, strct)
+}
+
+// UnpackFromReaderContext
+/*
+   UnpackFromReaderContext reads one message from reader like UnpackFromReader does, and stops when ctx is done,
+   both while waiting for a packer and while reading, so a stalled stream does not hold on to a packer.
+   A read that is already blocked can only be interrupted if reader has a SetReadDeadline method, like net.Conn.
+   Any other reader is checked for cancellation between reads. The read deadline of reader is cleared afterwards.
+*/
+func (p *BytePack) UnpackFromReaderContext(ctx context.Context, reader BPReader, strct interface{}) error {
+	r, stop := watchReader(ctx, reader, reader)
+	err := p.unpackFromReaderContext(ctx, r, strct)
+	stop()
+	return contextError(ctx, err)
+}
+
+func (p *BytePack) unpackFromReaderContext(ctx context.Context, reader BPReader, strct interface{}) error {
+	if p.framed() {
+		data, err := p.readEnvelope(reader)
+		if err != nil {
+			return err
+		}
+		s, err := p.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		return p.unpackPayloadWith(s, data, strct)
+	}
+	s, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.UnpackFromReader(reader, strct)
+	// now put the packer back into the pool
+	p.put(s)
+	return err
+}
+
+// UnpackFromIOReaderContext is UnpackFromReaderContext for an io.Reader
+func (p *BytePack) UnpackFromIOReaderContext(ctx context.Context, reader io.Reader, strct interface{}) error {
+	r, stop := watchReader(ctx, bytePackReader{reader}, reader)
+	err := p.unpackFromReaderContext(ctx, r, strct)
+	stop()
+	return contextError(ctx, err)
+}
+
+// contextError reports why reading failed: reads fail in all sorts of ways once the context is done, and the context tells why.
+// A read deadline taken from the context can expire just before the context itself notices.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// readDeadliner is implemented by readers whose blocked reads can be interrupted, such as net.Conn
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// contextReader fails every read once its context is done
+type contextReader struct {
+	BPReader
+	ctx context.Context
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	default:
+	}
+	return r.BPReader.Read(p)
+}
+
+func (r contextReader) ReadByte() (byte, error) {
+	select {
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	default:
+	}
+	return r.BPReader.ReadByte()
+}
+
+// watchReader makes the reads from reader stop once ctx is done. If source, the stream reader reads from,
+// supports read deadlines, the deadline of ctx is applied to it and blocked reads are interrupted on cancellation.
+// stop must be called once reading is over.
+func watchReader(ctx context.Context, reader BPReader, source io.Reader) (BPReader, func()) {
+	if ctx.Done() == nil {
+		// the context can never be cancelled
+		return reader, func() {}
+	}
+	r := contextReader{BPReader: reader, ctx: ctx}
+	d, ok := source.(readDeadliner)
+	if !ok {
+		return r, func() {}
+	}
+	deadline, _ := ctx.Deadline()
+	_ = d.SetReadDeadline(deadline)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			// a deadline in the past wakes up a blocked read right away
+			_ = d.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return r, func() {
+		close(done)
+		<-finished
+		_ = d.SetReadDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,143 @@
+package bytepack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBytePack_TryPackWithBusyPackers(t *testing.T) {
+	bp := NewBytePack(1)
+	a := person{Name: "Tester", Age: 30, Height: 5.25}
+
+	busy := bp.get()
+	_, err := bp.TryPack(a)
+	assert.ErrorIs(t, err, ErrNoPackerAvailable)
+	err = bp.TryUnpack([]byte{0}, &person{})
+	assert.ErrorIs(t, err, ErrNoPackerAvailable)
+	bp.put(busy)
+
+	packed, err := bp.TryPack(a)
+	assert.NoError(t, err)
+	var a2 person
+	assert.NoError(t, bp.TryUnpack(packed, &a2))
+	assert.Equal(t, a, a2)
+}
+
+func TestBytePack_PackContextWithBusyPackers(t *testing.T) {
+	bp := NewBytePack(1, WithCRC32C())
+	a := person{Name: "Tester", Age: 30, Height: 5.25}
+	packed, err := bp.Pack(a)
+	assert.NoError(t, err)
+
+	busy := bp.get()
+	timeoutCtx, cancelTimeout := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelTimeout()
+	_, err = bp.PackContext(timeoutCtx, a)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err = bp.UnpackContext(cancelCtx, packed, &person{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		bp.put(busy)
+	}()
+	packed, err = bp.PackContext(context.Background(), a)
+	assert.NoError(t, err)
+
+	var a2 person
+	assert.NoError(t, bp.UnpackContext(context.Background(), packed, &a2))
+	assert.Equal(t, a, a2)
+
+	// a context that is already done fails even with free packers
+	_, err = bp.PackContext(cancelCtx, a)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestBytePack_UnpackFromIOReaderContextOnStalledConnection(t *testing.T) {
+	for _, bp := range []*BytePack{NewBytePack(1), NewBytePack(1, WithCRC32C())} {
+		a := person{Name: "Tester", Age: 30, Height: 5.25}
+		packed, err := bp.Pack(a)
+		assert.NoError(t, err)
+
+		client, server := net.Pipe()
+		go func() {
+			// only part of the message arrives, then the connection stalls
+			_, _ = client.Write(packed[:5])
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err = bp.UnpackFromIOReaderContext(ctx, server, &person{})
+		cancel()
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+
+		// the packer went back to the pool
+		_, err = bp.TryPack(a)
+		assert.NoError(t, err)
+
+		// the connection is still usable for the next message, with its deadline cleared
+		go func() {
+			_, _ = client.Write(packed)
+		}()
+		var a2 person
+		assert.NoError(t, bp.UnpackFromIOReaderContext(context.Background(), server, &a2))
+		assert.Equal(t, a, a2)
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestBytePack_UnpackFromIOReaderContextCancelled(t *testing.T) {
+	bp := NewBytePack(1)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	err := bp.UnpackFromIOReaderContext(ctx, server, &person{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// cancellingReader cancels its context after the first read
+type cancellingReader struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (r cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.cancel()
+	return n, err
+}
+
+func TestBytePack_UnpackFromReaderContextStopsBetweenReads(t *testing.T) {
+	bp := NewBytePack(1)
+	a := person{Name: "Tester", Age: 30, Height: 5.25}
+	packed, err := bp.Pack(a)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = bp.UnpackFromIOReaderContext(ctx, cancellingReader{bytes.NewReader(packed), cancel}, &person{})
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	var a2 person
+	assert.NoError(t, bp.UnpackFromReaderContext(context.Background(), bytes.NewBuffer(packed), &a2))
+	assert.Equal(t, a, a2)
+}
@@ -0,0 +1,237 @@
+package bytepack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// DefaultMaxDepth is the nesting depth a Packer allows unless configured otherwise with WithMaxDepth
+const DefaultMaxDepth = 1000000
+
+// stackHopWalks is the number of traversals nested in one another, such as those of encrypted fields inside
+// encrypted fields, that run on one goroutine stack before moving on to a fresh one
+const stackHopWalks = 256
+
+// maxKeptFrames is the capacity of the frame stack a packer keeps between messages
+const maxKeptFrames = 4096
+
+// maxSpareEntries is the number of map traversal states a packer keeps for reuse
+const maxSpareEntries = 16
+
+// ErrMaxDepthExceeded is returned when a value, or a message, nests deeper than the Packer allows
+var ErrMaxDepthExceeded = errors.New("maximum nesting depth exceeded")
+
+// WithMaxDepth
+/*
+   WithMaxDepth limits how deeply the values a Packer encodes, and the messages it decodes, skips or sizes, may nest.
+   Every struct, slice, array and map, and every pointer and interface that is not nil, is a level, so each node
+   of a linked list takes two: its pointer and its struct. Going deeper fails with ErrMaxDepthExceeded.
+   A depth of 0 or less selects DefaultMaxDepth.
+   Values are traversed without recursion, so deep values do not overflow the goroutine stack, and each level
+   being traversed takes around a hundred bytes.
+*/
+func WithMaxDepth(depth int) PackerOption {
+	return func(s *Packer) {
+		s.maxDepth = depth
+	}
+}
+
+func (s *Packer) depthLimit() int {
+	if s.maxDepth <= 0 {
+		return DefaultMaxDepth
+	}
+	return s.maxDepth
+}
+
+// frameKind tells what a frame traverses
+type frameKind uint8
+
+const (
+	// structFrame traverses the fields of a struct
+	structFrame frameKind = iota
+	// elemsFrame traverses the elements of a slice or an array
+	elemsFrame
+	// mapFrame traverses the entries of a map
+	mapFrame
+	// valueFrame traverses the value a pointer or an interface holds
+	valueFrame
+)
+
+// frame is one nesting level being traversed. Values are traversed with frames on a stack of the packer instead
+// of with recursive calls, so a level takes a frame instead of several kilobytes of goroutine stack.
+type frame struct {
+	kind frameKind
+	// v is the value traversed, and t its type when a message is skipped without a value
+	v reflect.Value
+	t reflect.Type
+	// fields are the fields of a struct, or of the elements of a collection of structs
+	fields []fieldInfo
+	// i is the next field, element or entry to traverse, out of n
+	i, n int
+	// elem is the value a pointer or an interface holds
+	elem reflect.Value
+	// entries is the state of a map traversal
+	entries *mapEntries
+}
+
+// the steps of a map entry
+const (
+	keyNext = iota
+	valueNext
+	entryDone
+)
+
+// mapEntries holds the state of a map traversal
+type mapEntries struct {
+	step       int
+	iter       reflect.MapIter
+	key, value reflect.Value
+	// sorted holds the entries of a canonical map in order
+	sorted *sortedEntries
+}
+
+// push descends a nesting level into f, failing if that exceeds the depth limit, and pop goes back up
+func (s *Packer) push(f frame) error {
+	if s.depth >= s.depthLimit() {
+		return s.depthError()
+	}
+	s.depth++
+	if len(s.frames) == cap(s.frames) {
+		// double the stack, which copies the frames of deep values fewer times than append does
+		frames := make([]frame, len(s.frames), 2*cap(s.frames)+16)
+		copy(frames, s.frames)
+		s.frames = frames
+	}
+	s.frames = append(s.frames, f)
+	return nil
+}
+
+func (s *Packer) pop() {
+	last := len(s.frames) - 1
+	if entries := s.frames[last].entries; entries != nil && len(s.spareEntries) < maxSpareEntries {
+		*entries = mapEntries{}
+		s.spareEntries = append(s.spareEntries, entries)
+	}
+	// drop the values of the frame, so they can be collected
+	s.frames[last] = frame{}
+	s.frames = s.frames[:last]
+	s.depth--
+}
+
+// newMapEntries returns the state of a new map traversal, reusing the one of a finished traversal if it can
+func (s *Packer) newMapEntries() *mapEntries {
+	last := len(s.spareEntries) - 1
+	if last < 0 {
+		return &mapEntries{}
+	}
+	entries := s.spareEntries[last]
+	s.spareEntries[last] = nil
+	s.spareEntries = s.spareEntries[:last]
+	return entries
+}
+
+// top returns the frame being traversed. It is only valid until the next push.
+func (s *Packer) top() *frame {
+	return &s.frames[len(s.frames)-1]
+}
+
+// enter descends a nesting level without a frame, for chunked collections whose chunks are traversed apart,
+// and leave goes back up
+func (s *Packer) enter() error {
+	if s.depth >= s.depthLimit() {
+		return s.depthError()
+	}
+	s.depth++
+	return nil
+}
+
+func (s *Packer) leave() {
+	s.depth--
+}
+
+// reach checks that a collection whose elements are read or written in bulk, such as a slice of numbers,
+// stays within the depth limit
+func (s *Packer) reach() error {
+	if s.depth >= s.depthLimit() {
+		return s.depthError()
+	}
+	return nil
+}
+
+func (s *Packer) depthError() error {
+	return fmt.Errorf("%w: more than %d levels", ErrMaxDepthExceeded, s.depthLimit())
+}
+
+// beginWalk starts a traversal of the frames pushed above base. Traversals nest in one another only through
+// encrypted fields, Packable types and the like, but when they do, beginWalk reports false every stackHopWalks
+// traversals and the traversal continues with onFreshStack, so the goroutine stack stops growing.
+func (s *Packer) beginWalk() bool {
+	if s.nested-s.stackBase >= stackHopWalks {
+		return false
+	}
+	s.nested++
+	return true
+}
+
+// endWalk ends a traversal, popping the frames above base that an error or a panic left behind
+func (s *Packer) endWalk(base int) {
+	s.nested--
+	for len(s.frames) > base {
+		s.pop()
+	}
+	if base == 0 && cap(s.frames) > maxKeptFrames {
+		// do not hold on to the stack of an unusually deep value
+		s.frames = nil
+	}
+}
+
+// onFreshStack runs traverse on a new goroutine and waits for it. Panics are passed on to the caller.
+func (s *Packer) onFreshStack(traverse func() error) error {
+	stackBase := s.stackBase
+	s.stackBase = s.nested
+	defer func() {
+		s.stackBase = stackBase
+	}()
+	var err error
+	var panicked interface{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			panicked = recover()
+		}()
+		err = traverse()
+	}()
+	<-done
+	if panicked != nil {
+		panic(panicked)
+	}
+	return err
+}
+
+// pushStruct pushes a frame for the given fields of struct v
+func (s *Packer) pushStruct(v reflect.Value, fields []fieldInfo) error {
+	if s.unexportedFields == IncludeUnexported && !v.CanAddr() {
+		// unexported fields are read through their address, so work on an addressable copy
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		v = c
+	}
+	return s.push(frame{kind: structFrame, v: v, fields: fields})
+}
+
+// elemFields returns the fields of elements of type t when they are structs, so a frame of elements looks them
+// up once instead of once per element
+func (s *Packer) elemFields(t reflect.Type) []fieldInfo {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	return s.structFields(t)
+}
+
+// bulkKind reports whether the elements of a collection of kind k elements are read and written in bulk,
+// without a nesting level of their own
+func (s *Packer) bulkKind(k reflect.Kind) bool {
+	return k == reflect.String || s.wireSize(k) > 0
+}
@@ -0,0 +1,193 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+type listNode struct {
+	Value int64
+	Label string
+	Next  *listNode
+}
+
+type treeNode struct {
+	Name     string
+	Children []treeNode
+}
+
+func newList(n int) *listNode {
+	var head *listNode
+	for i := n - 1; i >= 0; i-- {
+		head = &listNode{Value: int64(i), Label: "node", Next: head}
+	}
+	return head
+}
+
+func listLen(head *listNode) int {
+	n := 0
+	for ; head != nil; head = head.Next {
+		n++
+	}
+	return n
+}
+
+func TestPacker_DeepLinkedList(t *testing.T) {
+	p := NewPacker()
+	head := newList(20000)
+	packed, err := p.Pack(head)
+	assert.NoError(t, err)
+
+	size, err := p.Size(head)
+	assert.NoError(t, err)
+	assert.Equal(t, len(packed), size)
+
+	var head2 listNode
+	assert.NoError(t, p.Unpack(packed, &head2))
+	assert.Equal(t, 20000, listLen(&head2))
+	assert.Equal(t, head, &head2)
+
+	assert.NoError(t, p.Validate(packed, reflect.TypeOf(head2)))
+	assert.NoError(t, p.Skip(bytes.NewBuffer(packed), reflect.TypeOf(head2)))
+
+	var head3 listNode
+	assert.NoError(t, p.UnpackInto(packed, &head3, MergeOverwrite))
+	assert.Equal(t, head, &head3)
+	assert.Equal(t, 0, p.depth)
+}
+
+func TestPacker_DeepTree(t *testing.T) {
+	root := treeNode{Name: "leaf"}
+	for i := 0; i < 5000; i++ {
+		root = treeNode{Name: "branch", Children: []treeNode{root}}
+	}
+	bp := NewBytePack(1)
+	packed, err := bp.Pack(root)
+	assert.NoError(t, err)
+	var root2 treeNode
+	assert.NoError(t, bp.Unpack(packed, &root2))
+	assert.Equal(t, root, root2)
+}
+
+func TestPacker_MaxDepth(t *testing.T) {
+	// a list of 50 nodes nests 100 levels
+	p := NewPacker(WithMaxDepth(100))
+	_, err := p.Pack(newList(51))
+	assert.ErrorIs(t, err, ErrMaxDepthExceeded)
+	_, err = p.Size(newList(51))
+	assert.ErrorIs(t, err, ErrMaxDepthExceeded)
+
+	// the depth goes back to zero after an error
+	assert.Equal(t, 0, p.depth)
+	packed, err := p.Pack(newList(50))
+	assert.NoError(t, err)
+	var head listNode
+	assert.NoError(t, p.Unpack(packed, &head))
+	assert.Equal(t, 50, listLen(&head))
+	assert.NoError(t, p.Validate(packed, reflect.TypeOf(listNode{})))
+	assert.NoError(t, p.UnpackInto(packed, &listNode{}, MergeOverwrite))
+
+	// messages nesting too deeply are rejected when decoding
+	packed, err = NewPacker().Pack(newList(51))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, p.Unpack(packed, &listNode{}), ErrMaxDepthExceeded)
+	assert.ErrorIs(t, p.Validate(packed, reflect.TypeOf(listNode{})), ErrMaxDepthExceeded)
+	assert.ErrorIs(t, p.UnpackInto(packed, &listNode{}, MergeOverwrite), ErrMaxDepthExceeded)
+	assert.Equal(t, 0, p.depth)
+}
+
+type nestedSlice []nestedSlice
+
+type nestedMap map[string]nestedMap
+
+func newNestedSlice(depth int) nestedSlice {
+	n := nestedSlice{}
+	for i := 1; i < depth; i++ {
+		n = nestedSlice{n}
+	}
+	return n
+}
+
+func TestPacker_MaxDepthCollections(t *testing.T) {
+	p := NewPacker(WithMaxDepth(10))
+	_, err := p.Pack(newNestedSlice(11))
+	assert.ErrorIs(t, err, ErrMaxDepthExceeded)
+	_, err = p.Size(newNestedSlice(11))
+	assert.ErrorIs(t, err, ErrMaxDepthExceeded)
+	packed, err := p.Pack(newNestedSlice(10))
+	assert.NoError(t, err)
+	var n nestedSlice
+	assert.NoError(t, p.Unpack(packed, &n))
+	assert.Equal(t, newNestedSlice(10), n)
+
+	packed, err = NewPacker().Pack(newNestedSlice(100))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, p.Unpack(packed, &n), ErrMaxDepthExceeded)
+	assert.ErrorIs(t, p.Validate(packed, reflect.TypeOf(n)), ErrMaxDepthExceeded)
+	assert.ErrorIs(t, p.UnpackInto(packed, &n, MergeOverwrite), ErrMaxDepthExceeded)
+
+	// maps and arrays count too
+	deepMap := nestedMap{}
+	for i := 0; i < 10; i++ {
+		deepMap = nestedMap{"next": deepMap}
+	}
+	_, err = p.Pack(deepMap)
+	assert.ErrorIs(t, err, ErrMaxDepthExceeded)
+	_, err = p.Pack([1][1][1][1][1][1][1][1][1][1][1]int{})
+	assert.ErrorIs(t, err, ErrMaxDepthExceeded)
+	assert.Equal(t, 0, p.depth)
+}
+
+func TestPacker_MaxDepthCraftedMessage(t *testing.T) {
+	// a few megabytes of slices holding one slice each
+	level := []byte{0, 0, 0, 0, 1}
+	crafted := append(bytes.Repeat(level, 4000000), 1)
+	var n nestedSlice
+	p := NewPacker(WithMaxDepth(10))
+	assert.ErrorIs(t, p.Unpack(crafted, &n), ErrMaxDepthExceeded)
+	assert.ErrorIs(t, p.Validate(crafted, reflect.TypeOf(n)), ErrMaxDepthExceeded)
+	assert.ErrorIs(t, p.UnpackInto(crafted, &n, MergeOverwrite), ErrMaxDepthExceeded)
+	assert.ErrorIs(t, NewPacker().Unpack(crafted, &n), ErrMaxDepthExceeded)
+}
+
+type sealedNode struct {
+	Value int64
+	Next  *sealedNode `bytepack:"encrypt"`
+}
+
+func TestPacker_NestedTraversalsAcrossFreshStacks(t *testing.T) {
+	// every encrypted field is packed with a traversal of its own, nested in the one of its struct
+	p := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey))
+	var head *sealedNode
+	for i := 0; i < 3*stackHopWalks; i++ {
+		head = &sealedNode{Value: int64(i), Next: head}
+	}
+	packed, err := p.Pack(head)
+	assert.NoError(t, err)
+	size, err := p.Size(head)
+	assert.NoError(t, err)
+	assert.Equal(t, len(packed), size)
+
+	var head2 sealedNode
+	assert.NoError(t, p.Unpack(packed, &head2))
+	assert.Equal(t, head, &head2)
+	assert.Equal(t, 0, p.depth)
+	assert.Equal(t, 0, p.nested)
+	assert.Equal(t, 0, p.stackBase)
+}
+
+func TestPacker_MaxDepthFramesReleased(t *testing.T) {
+	p := NewPacker(WithMaxDepth(3000))
+	_, err := p.Pack(newList(1501))
+	assert.ErrorIs(t, err, ErrMaxDepthExceeded)
+	assert.Equal(t, 0, p.depth)
+	assert.Empty(t, p.frames)
+
+	_, err = p.Pack(newList(1500))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p.depth)
+	// the frames of a deep value are not kept around
+	assert.LessOrEqual(t, cap(p.frames), maxKeptFrames)
+}
@@ -0,0 +1,70 @@
+package bytepack
+
+import "io"
+
+// encBuffer is the output of the encoder: a byte slice the Pack helpers store into directly.
+// Fixed-size values reserve their bytes with a single capacity check and fill them in place.
+// Writes never fail, the error results only keep it interchangeable with the io writers.
+type encBuffer struct {
+	b []byte
+}
+
+// Bytes returns the encoded bytes, which stay valid until the next write or Reset
+func (e *encBuffer) Bytes() []byte {
+	return e.b
+}
+
+func (e *encBuffer) Len() int {
+	return len(e.b)
+}
+
+func (e *encBuffer) Cap() int {
+	return cap(e.b)
+}
+
+// Reset empties the buffer but keeps its memory for reuse
+func (e *encBuffer) Reset() {
+	e.b = e.b[:0]
+}
+
+// Grow makes room for at least n more bytes
+func (e *encBuffer) Grow(n int) {
+	if cap(e.b)-len(e.b) >= n {
+		return
+	}
+	grown := make([]byte, len(e.b), 2*cap(e.b)+n)
+	copy(grown, e.b)
+	e.b = grown
+}
+
+// extend appends n bytes to the buffer and returns them for the caller to fill in
+func (e *encBuffer) extend(n int) []byte {
+	l := len(e.b)
+	if cap(e.b)-l < n {
+		e.Grow(n)
+	}
+	e.b = e.b[:l+n]
+	return e.b[l:]
+}
+
+func (e *encBuffer) Write(p []byte) (int, error) {
+	e.b = append(e.b, p...)
+	return len(p), nil
+}
+
+func (e *encBuffer) WriteByte(c byte) error {
+	e.b = append(e.b, c)
+	return nil
+}
+
+func (e *encBuffer) WriteString(s string) (int, error) {
+	e.b = append(e.b, s...)
+	return len(s), nil
+}
+
+// WriteTo writes the buffered bytes to w and empties the buffer
+func (e *encBuffer) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(e.b)
+	e.Reset()
+	return int64(n), err
+}
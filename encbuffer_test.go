@@ -0,0 +1,66 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPacker_PackHelpersWriteBigEndian(t *testing.T) {
+	s := NewPacker()
+	assert.NoError(t, s.PackInt16(-2))
+	assert.NoError(t, s.PackUint16(0x0102))
+	assert.NoError(t, s.PackInt32(-2))
+	assert.NoError(t, s.PackUint32(0x01020304))
+	assert.NoError(t, s.PackInt64(-2))
+	assert.NoError(t, s.PackUint64(0x0102030405060708))
+	assert.NoError(t, s.PackInt8(-1))
+	assert.NoError(t, s.PackBool(true))
+
+	expected := []byte{
+		0xff, 0xfe,
+		1, 2,
+		0xff, 0xff, 0xff, 0xfe,
+		1, 2, 3, 4,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe,
+		1, 2, 3, 4, 5, 6, 7, 8,
+		0xff,
+		1,
+	}
+	assert.Equal(t, expected, s.w.Bytes())
+
+	buf := bytes.NewBuffer(s.w.Bytes())
+	i16, _ := s.UnpackInt16(buf)
+	u16, _ := s.UnpackUint16(buf)
+	i32, _ := s.UnpackInt32(buf)
+	u32, _ := s.UnpackUint32(buf)
+	i64, _ := s.UnpackInt64(buf)
+	u64, err := s.UnpackUint64(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int16(-2), uint16(0x0102), int32(-2), uint32(0x01020304), int64(-2), uint64(0x0102030405060708)},
+		[]interface{}{i16, u16, i32, u32, i64, u64})
+}
+
+func TestPacker_EncBufferGrowsAndResets(t *testing.T) {
+	var e encBuffer
+	for i := 0; i < 1000; i++ {
+		copy(e.extend(3), []byte{byte(i), byte(i >> 8), 0xaa})
+	}
+	assert.Equal(t, 3000, e.Len())
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, []byte{byte(i), byte(i >> 8), 0xaa}, e.Bytes()[3*i:3*i+3])
+	}
+
+	capacity := e.Cap()
+	e.Reset()
+	assert.Equal(t, 0, e.Len())
+	assert.Equal(t, capacity, e.Cap())
+
+	var out bytes.Buffer
+	_, _ = e.WriteString("abc")
+	n, err := e.WriteTo(&out)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, "abc", out.String())
+	assert.Equal(t, 0, e.Len())
+}
@@ -0,0 +1,117 @@
+package bytepack
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrDecryptionFailed is returned when an encrypted message fails authentication, for example after being tampered with
+var ErrDecryptionFailed = errors.New("message authentication failed")
+
+// ErrNotEncrypted is returned when a BytePack with keys receives a message that is not encrypted
+var ErrNotEncrypted = errors.New("message is not encrypted")
+
+// ErrUnknownKey is returned, wrapped with the key ID, when a message is encrypted with a key the receiver does not have
+var ErrUnknownKey = errors.New("unknown key")
+
+// KeyProvider
+/*
+   KeyProvider supplies the AEAD keys for encrypting and decrypting messages. Every key has an ID that is
+   written into the encrypted message, so keys can be rotated while older messages are still in flight.
+*/
+type KeyProvider interface {
+	// CurrentKey returns the key new messages are encrypted with
+	CurrentKey() (uint32, cipher.AEAD, error)
+	// Key returns the key with the given ID
+	Key(id uint32) (cipher.AEAD, error)
+}
+
+// WithEncryption seals every packed message with the current key of keys and opens messages with any key in keys
+func WithEncryption(keys KeyProvider) BytePackOption {
+	return func(p *BytePack) {
+		p.keys = keys
+	}
+}
+
+// KeyRing
+/*
+   KeyRing is a thread safe KeyProvider. Keys are added under an ID with Add, and Use selects the key for new messages.
+   Any cipher.AEAD works, for example AES-GCM from AddAESGCM or ChaCha20-Poly1305 from golang.org/x/crypto.
+*/
+type KeyRing struct {
+	lock       sync.RWMutex
+	keys       map[uint32]cipher.AEAD
+	current    uint32
+	hasCurrent bool
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		keys: make(map[uint32]cipher.AEAD),
+	}
+}
+
+// Add adds a key under the given ID, replacing any previous key with the same ID
+func (k *KeyRing) Add(id uint32, aead cipher.AEAD) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.keys[id] = aead
+}
+
+// AddAESGCM adds an AES-GCM key under the given ID. The key must be 16, 24 or 32 bytes long
+func (k *KeyRing) AddAESGCM(id uint32, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.Add(id, aead)
+	return nil
+}
+
+// Use makes the key with the given ID the one new messages are encrypted with
+func (k *KeyRing) Use(id uint32) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if _, exists := k.keys[id]; !exists {
+		return fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	k.current = id
+	k.hasCurrent = true
+	return nil
+}
+
+// Remove removes the key with the given ID, so messages encrypted with it can no longer be decrypted
+func (k *KeyRing) Remove(id uint32) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	delete(k.keys, id)
+	if k.current == id {
+		k.hasCurrent = false
+	}
+}
+
+func (k *KeyRing) CurrentKey() (uint32, cipher.AEAD, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if !k.hasCurrent {
+		return 0, nil, errors.New("no key is in use")
+	}
+	return k.current, k.keys[k.current], nil
+}
+
+func (k *KeyRing) Key(id uint32) (cipher.AEAD, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	aead, exists := k.keys[id]
+	if !exists {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	return aead, nil
+}
@@ -0,0 +1,122 @@
+package bytepack
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func newTestKeyRing(t *testing.T, ids ...uint32) *KeyRing {
+	keys := NewKeyRing()
+	for _, id := range ids {
+		key := bytes.Repeat([]byte{byte(id)}, 32)
+		assert.NoError(t, keys.AddAESGCM(id, key))
+	}
+	assert.NoError(t, keys.Use(ids[len(ids)-1]))
+	return keys
+}
+
+func TestBytePack_Encryption(t *testing.T) {
+	bp := NewBytePack(1, WithEncryption(newTestKeyRing(t, 1)), WithCompression(NewFlateCompressor(flate.DefaultCompression), 0))
+	m := newSnapshotMsg(100)
+
+	packed, err := bp.Pack(m)
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(packed, []byte("key10")))
+
+	var m2 snapshotMsg
+	err = bp.Unpack(packed, &m2)
+	assert.NoError(t, err)
+	assert.Equal(t, m, m2)
+
+	var m3 snapshotMsg
+	err = bp.UnpackFromReader(bytes.NewBuffer(packed), &m3)
+	assert.NoError(t, err)
+	assert.Equal(t, m, m3)
+
+	// every message gets its own nonce
+	packed2, err := bp.Pack(m)
+	assert.NoError(t, err)
+	assert.NotEqual(t, packed, packed2)
+}
+
+func TestBytePack_EncryptionRejectsTampering(t *testing.T) {
+	bp := NewBytePack(1, WithEncryption(newTestKeyRing(t, 1)))
+	packed, err := bp.Pack(person{Name: "Tester", Age: 30, Height: 5.25})
+	assert.NoError(t, err)
+
+	// the flags, key ID and length come first and are caught by their own checks
+	for i := 9; i < len(packed); i++ {
+		tampered := make([]byte, len(packed))
+		copy(tampered, packed)
+		tampered[i] ^= 0x10
+
+		var p person
+		err = bp.Unpack(tampered, &p)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	}
+
+	tampered := make([]byte, len(packed))
+	copy(tampered, packed)
+	tampered[4] ^= 0x01 // key ID
+	var p person
+	err = bp.Unpack(tampered, &p)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	plain, err := NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 1<<20)).Pack(person{Name: "Tester"})
+	assert.NoError(t, err)
+	err = bp.Unpack(plain, &p)
+	assert.ErrorIs(t, err, ErrNotEncrypted)
+}
+
+func TestBytePack_EncryptionKeyRotation(t *testing.T) {
+	senderKeys := newTestKeyRing(t, 1)
+	receiverKeys := newTestKeyRing(t, 1, 2)
+	sender := NewBytePack(1, WithEncryption(senderKeys))
+	receiver := NewBytePack(1, WithEncryption(receiverKeys))
+	a := person{Name: "Tester", Age: 30, Height: 5.25}
+
+	oldKey, err := sender.Pack(a)
+	assert.NoError(t, err)
+
+	assert.NoError(t, senderKeys.AddAESGCM(2, bytes.Repeat([]byte{2}, 32)))
+	assert.NoError(t, senderKeys.Use(2))
+	newKey, err := sender.Pack(a)
+	assert.NoError(t, err)
+
+	for _, packed := range [][]byte{oldKey, newKey} {
+		var a2 person
+		err = receiver.Unpack(packed, &a2)
+		assert.NoError(t, err)
+		assert.Equal(t, a, a2)
+	}
+
+	receiverKeys.Remove(1)
+	var a3 person
+	err = receiver.Unpack(oldKey, &a3)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Error(t, receiverKeys.Use(1))
+}
+
+func TestBytePack_EncryptionWithCustomAEAD(t *testing.T) {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{7}, 16))
+	assert.NoError(t, err)
+	aead, err := cipher.NewGCMWithNonceSize(block, 16)
+	assert.NoError(t, err)
+	keys := NewKeyRing()
+	keys.Add(7, aead)
+	assert.NoError(t, keys.Use(7))
+
+	bp := NewBytePack(1, WithEncryption(keys), WithCRC32C())
+	a := person{Name: "Tester", Age: 30, Height: 5.25}
+	packed, err := bp.Pack(a)
+	assert.NoError(t, err)
+
+	var a2 person
+	err = bp.Unpack(packed, &a2)
+	assert.NoError(t, err)
+	assert.Equal(t, a, a2)
+}
@@ -0,0 +1,306 @@
+package bytepack
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+/*
+   When a BytePack compresses, checksums or encrypts messages, every packed message is wrapped into an envelope:
+
+   [flags uint8][compressor id uint8, if compressed][key id uint32, if encrypted][body length uint32][body][checksum, if checksummed]
+
+   The body is the Packer payload, compressed and then encrypted if the flags say so.
+   An encrypted body starts with the nonce and is authenticated together with the header.
+   The checksum covers everything before it.
+*/
+
+const (
+	envelopeCompressed uint8 = 1 << iota
+	envelopeChecksummed
+	envelopeEncrypted
+)
+
+const envelopeKnownFlags = envelopeCompressed | envelopeChecksummed | envelopeEncrypted
+
+// envelopeOverhead is room for the envelope header, the nonce and tag of common AEADs, and common checksums
+const envelopeOverhead = 64
+
+// ErrChecksumMismatch is returned when a packed message does not match its checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+type envelopeHeader struct {
+	flags        uint8
+	compressorID uint8
+	keyID        uint32
+	bodyLen      uint32
+}
+
+// framed reports whether the BytePack wraps packed messages into envelopes
+func (p *BytePack) framed() bool {
+	return p.compressor != nil || p.newChecksum != nil || p.keys != nil
+}
+
+// seal wraps payload into an envelope, which it appends to dst[:0]
+func (p *BytePack) seal(dst []byte, payload []byte) ([]byte, error) {
+	header := envelopeHeader{}
+	body := payload
+	if p.compressor != nil && len(payload) >= p.compressThreshold {
+		compressed, err := compress(p.compressor, payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(payload) {
+			body = compressed
+			header.flags |= envelopeCompressed
+			header.compressorID = p.compressor.ID()
+		}
+	}
+	header.bodyLen = uint32(len(body))
+
+	var aead cipher.AEAD
+	if p.keys != nil {
+		var err error
+		header.keyID, aead, err = p.keys.CurrentKey()
+		if err != nil {
+			return nil, err
+		}
+		header.flags |= envelopeEncrypted
+		header.bodyLen = uint32(aead.NonceSize() + len(body) + aead.Overhead())
+	}
+	var h hash.Hash
+	if p.newChecksum != nil {
+		h = p.newChecksum()
+		header.flags |= envelopeChecksummed
+	}
+	out := dst[:0]
+	if size := 10 + int(header.bodyLen) + checksumSize(h); cap(out) < size {
+		out = make([]byte, 0, size)
+	}
+	out = header.appendTo(out)
+	if aead != nil {
+		headerLen := len(out)
+		out = out[:headerLen+aead.NonceSize()]
+		nonce := out[headerLen:]
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return nil, err
+		}
+		out = aead.Seal(out, nonce, body, out[:headerLen])
+	} else {
+		out = append(out, body...)
+	}
+	if h != nil {
+		h.Write(out)
+		out = h.Sum(out)
+	}
+	return out, nil
+}
+
+// open returns the Packer payload of an envelope held in memory
+func (p *BytePack) open(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	header, err := readEnvelopeHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := p.checksumFor(header)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := len(data) - r.Len()
+	rest := data[headerLen:]
+	if uint64(header.bodyLen)+uint64(checksumSize(h)) > uint64(len(rest)) {
+		if h != nil {
+			// most likely a corrupted length
+			return nil, ErrChecksumMismatch
+		}
+		return nil, errors.New(fmt.Sprintf("envelope body of %d bytes is longer than the remaining %d bytes", header.bodyLen, len(rest)))
+	}
+	body := rest[:header.bodyLen]
+	if h != nil {
+		end := headerLen + int(header.bodyLen)
+		h.Write(data[:end])
+		if !bytes.Equal(h.Sum(nil), data[end:end+h.Size()]) {
+			return nil, ErrChecksumMismatch
+		}
+	}
+	return p.openBody(header, data[:headerLen], body)
+}
+
+// readEnvelope reads a complete envelope from a stream and returns its Packer payload
+func (p *BytePack) readEnvelope(r BPReader) ([]byte, error) {
+	header, err := readEnvelopeHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	h, err := p.checksumFor(header)
+	if err != nil {
+		return nil, err
+	}
+	if p.maxMessageSize > 0 && int64(header.bodyLen) > int64(p.maxMessageSize) {
+		return nil, errors.New(fmt.Sprintf("envelope body of %d bytes exceeds the maximum message size", header.bodyLen))
+	}
+	// copy instead of allocating the body upfront, so a corrupted length cannot make us allocate a huge buffer
+	var body bytes.Buffer
+	_, err = io.CopyN(&body, r, int64(header.bodyLen))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if h != nil {
+		sum := make([]byte, h.Size())
+		_, err = io.ReadFull(r, sum)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		h.Write(header.appendTo(nil))
+		h.Write(body.Bytes())
+		if !bytes.Equal(h.Sum(nil), sum) {
+			return nil, ErrChecksumMismatch
+		}
+	}
+	return p.openBody(header, header.appendTo(nil), body.Bytes())
+}
+
+// checksumFor returns a fresh checksum for the envelope, or nil if the envelope has none
+func (p *BytePack) checksumFor(header envelopeHeader) (hash.Hash, error) {
+	if header.flags&envelopeChecksummed == 0 {
+		if p.newChecksum != nil {
+			// the checksum flag itself may have been flipped
+			return nil, ErrChecksumMismatch
+		}
+		return nil, nil
+	}
+	if p.newChecksum == nil {
+		return nil, errors.New("message has a checksum, but the BytePack has no checksum configured")
+	}
+	return p.newChecksum(), nil
+}
+
+func checksumSize(h hash.Hash) int {
+	if h == nil {
+		return 0
+	}
+	return h.Size()
+}
+
+func (p *BytePack) openBody(header envelopeHeader, headerBytes []byte, body []byte) ([]byte, error) {
+	if header.flags&envelopeEncrypted != 0 {
+		if p.keys == nil {
+			return nil, errors.New("message is encrypted, but the BytePack has no keys")
+		}
+		aead, err := p.keys.Key(header.keyID)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) < aead.NonceSize() {
+			return nil, ErrDecryptionFailed
+		}
+		body, err = aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], headerBytes)
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+	} else if p.keys != nil {
+		return nil, ErrNotEncrypted
+	}
+	if header.flags&envelopeCompressed != 0 {
+		c := p.compressor
+		if c == nil || c.ID() != header.compressorID {
+			var err error
+			c, err = registeredCompressor(header.compressorID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return decompress(c, body, p.decompressedLimit())
+	}
+	return body, nil
+}
+
+func (h envelopeHeader) appendTo(out []byte) []byte {
+	out = append(out, h.flags)
+	if h.flags&envelopeCompressed != 0 {
+		out = append(out, h.compressorID)
+	}
+	var lenBytes [4]byte
+	if h.flags&envelopeEncrypted != 0 {
+		binary.BigEndian.PutUint32(lenBytes[:], h.keyID)
+		out = append(out, lenBytes[:]...)
+	}
+	binary.BigEndian.PutUint32(lenBytes[:], h.bodyLen)
+	return append(out, lenBytes[:]...)
+}
+
+func readEnvelopeHeader(r BPReader) (envelopeHeader, error) {
+	header := envelopeHeader{}
+	var err error
+	header.flags, err = r.ReadByte()
+	if err != nil {
+		return header, err
+	}
+	if header.flags&^envelopeKnownFlags != 0 {
+		return header, errors.New(fmt.Sprintf("unknown envelope flags %08b", header.flags))
+	}
+	if header.flags&envelopeCompressed != 0 {
+		header.compressorID, err = r.ReadByte()
+		if err != nil {
+			return header, err
+		}
+	}
+	var lenBytes [4]byte
+	if header.flags&envelopeEncrypted != 0 {
+		_, err = io.ReadFull(r, lenBytes[:])
+		if err != nil {
+			return header, err
+		}
+		header.keyID = binary.BigEndian.Uint32(lenBytes[:])
+	}
+	_, err = io.ReadFull(r, lenBytes[:])
+	if err != nil {
+		return header, err
+	}
+	header.bodyLen = binary.BigEndian.Uint32(lenBytes[:])
+	return header, nil
+}
+
+func compress(c Compressor, payload []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	w, err := c.NewWriter(&compressed)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(payload)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// decompress decompresses body, failing once the output grows past limit bytes
+func decompress(c Compressor, body []byte, limit int64) ([]byte, error) {
+	r, err := c.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// read one byte past the limit to tell a message of exactly limit bytes from a longer one
+	payload, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(payload)) > limit {
+		return nil, errors.New(fmt.Sprintf("decompressed message exceeds the limit of %d bytes", limit))
+	}
+	return payload, nil
+}
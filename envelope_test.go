@@ -0,0 +1,171 @@
+package bytepack
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strconv"
+	"testing"
+)
+
+type snapshotMsg struct {
+	Ballot int32
+	Slot   int64
+	Data   map[string][]byte
+	Blob   []byte
+}
+
+func newSnapshotMsg(n int) snapshotMsg {
+	m := snapshotMsg{
+		Ballot: 3,
+		Slot:   1000,
+		Data:   make(map[string][]byte),
+		Blob:   bytes.Repeat([]byte("snapshot"), n),
+	}
+	for i := 0; i < n; i++ {
+		m.Data["key"+strconv.Itoa(i)] = []byte("value" + strconv.Itoa(i))
+	}
+	return m
+}
+
+func TestBytePack_Compression(t *testing.T) {
+	compressors := []Compressor{
+		NewFlateCompressor(flate.BestSpeed),
+		NewGzipCompressor(gzip.DefaultCompression),
+		NewZlibCompressor(zlib.BestCompression),
+	}
+	m := newSnapshotMsg(1000)
+	plain, err := NewPacker().Pack(m)
+	assert.NoError(t, err)
+
+	for _, c := range compressors {
+		bp := NewBytePack(2, WithCompression(c, 1024))
+		packed, err := bp.Pack(m)
+		assert.NoError(t, err)
+		assert.Less(t, len(packed), len(plain)/2)
+		assert.Equal(t, envelopeCompressed, packed[0])
+		assert.Equal(t, c.ID(), packed[1])
+
+		var m2 snapshotMsg
+		err = bp.Unpack(packed, &m2)
+		assert.NoError(t, err)
+		assert.Equal(t, m, m2)
+
+		// any compressing BytePack can unpack it, as well as read it from a stream
+		var m3 snapshotMsg
+		reader := bytes.NewBuffer(append(packed, packed...))
+		other := NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 1024))
+		err = other.UnpackFromReader(reader, &m3)
+		assert.NoError(t, err)
+		assert.Equal(t, m, m3)
+		err = other.UnpackFromIOReader(reader, &m3)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, reader.Len())
+	}
+}
+
+func TestBytePack_CompressionThreshold(t *testing.T) {
+	bp := NewBytePack(1, WithCompression(NewZlibCompressor(zlib.DefaultCompression), 4096))
+	small := person{Name: "Tester", Age: 30, Height: 5.25}
+	plain, err := NewPacker().Pack(small)
+	assert.NoError(t, err)
+
+	packed, err := bp.Pack(small)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(0), packed[0])
+	assert.Equal(t, plain, packed[5:])
+
+	var small2 person
+	err = bp.Unpack(packed, &small2)
+	assert.NoError(t, err)
+	assert.Equal(t, small, small2)
+}
+
+func TestBytePack_DecompressionLimit(t *testing.T) {
+	// a few kilobytes that decompress into 64 MB of zeros
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestCompression)
+	assert.NoError(t, err)
+	zeros := make([]byte, 1<<20)
+	for i := 0; i < 64; i++ {
+		_, err = w.Write(zeros)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+	assert.Less(t, compressed.Len(), 1<<17)
+	header := envelopeHeader{flags: envelopeCompressed, compressorID: FlateCompressorID, bodyLen: uint32(compressed.Len())}
+	bomb := append(header.appendTo(nil), compressed.Bytes()...)
+
+	bp := NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 1024), WithMaxDecompressedSize(1<<20))
+	err = bp.Unpack(bomb, &snapshotMsg{})
+	assert.ErrorContains(t, err, "exceeds the limit of 1048576 bytes")
+	err = bp.UnpackFromReader(bytes.NewBuffer(bomb), &snapshotMsg{})
+	assert.ErrorContains(t, err, "exceeds the limit")
+
+	// the maximum message size applies when there is no decompression limit of its own
+	bp = NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 1024), WithMaxMessageSize(1<<20))
+	err = bp.Unpack(bomb, &snapshotMsg{})
+	assert.ErrorContains(t, err, "exceeds the limit of 1048576 bytes")
+
+	// a message right at the limit still decompresses
+	m := newSnapshotMsg(1000)
+	plain, err := NewPacker().Pack(m)
+	assert.NoError(t, err)
+	bp = NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 1024), WithMaxDecompressedSize(len(plain)))
+	packed, err := bp.Pack(m)
+	assert.NoError(t, err)
+	var m2 snapshotMsg
+	assert.NoError(t, bp.Unpack(packed, &m2))
+	assert.Equal(t, m, m2)
+	bp = NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 1024), WithMaxDecompressedSize(len(plain)-1))
+	assert.Error(t, bp.Unpack(packed, &m2))
+}
+
+// renamedCompressor is a custom compressor that reuses flate under a different ID
+type renamedCompressor struct {
+	Compressor
+}
+
+func (c renamedCompressor) ID() uint8 {
+	return 200
+}
+
+func TestBytePack_CustomCompressor(t *testing.T) {
+	c := renamedCompressor{NewFlateCompressor(flate.DefaultCompression)}
+	bp := NewBytePack(1, WithCompression(c, 0))
+	m := newSnapshotMsg(10)
+	packed, err := bp.Pack(m)
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(200), packed[1])
+
+	var m2 snapshotMsg
+	err = bp.Unpack(packed, &m2)
+	assert.NoError(t, err)
+	assert.Equal(t, m, m2)
+
+	// other BytePacks need the compressor to be registered
+	RegisterCompressor(c)
+	other := NewBytePack(1, WithCompression(NewGzipCompressor(gzip.DefaultCompression), 0))
+	var m3 snapshotMsg
+	err = other.Unpack(packed, &m3)
+	assert.NoError(t, err)
+	assert.Equal(t, m, m3)
+
+	_, err = registeredCompressor(201)
+	assert.Error(t, err)
+}
+
+func TestBytePack_EnvelopeTruncated(t *testing.T) {
+	bp := NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 0))
+	packed, err := bp.Pack(newSnapshotMsg(100))
+	assert.NoError(t, err)
+
+	var m snapshotMsg
+	err = bp.Unpack(packed[:len(packed)-10], &m)
+	assert.Error(t, err)
+	err = bp.UnpackFromReader(bytes.NewBuffer(packed[:len(packed)-10]), &m)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
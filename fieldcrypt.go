@@ -0,0 +1,161 @@
+package bytepack
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+/*
+   A struct field tagged with `bytepack:"encrypt"` is encoded on its own, with its own pointer identities,
+   and then sealed with the current field key:
+
+   [key id uint32][sealed length int32][nonce][ciphertext]
+
+   The sealed bytes are bound to the struct type and the field name, so they cannot be moved to another field.
+*/
+
+// MissingKeyPolicy
+/*
+   MissingKeyPolicy controls what unpacking does with an encrypted field when the Packer does not have its key.
+*/
+type MissingKeyPolicy uint8
+
+const (
+	// ZeroOnMissingKey leaves the field zeroed
+	ZeroOnMissingKey MissingKeyPolicy = iota
+	// ErrorOnMissingKey fails unpacking with ErrUnknownKey
+	ErrorOnMissingKey
+)
+
+// WithFieldEncryption
+/*
+   WithFieldEncryption encrypts struct fields tagged with `bytepack:"encrypt"` with the current key of keys.
+   When unpacking, fields are decrypted with any key in keys. Fields whose key is missing,
+   or all encrypted fields when keys is nil, are handled according to policy.
+   Sealed fields differ on every pack, so they have no canonical encoding: canonical Packers and Hash reject them,
+   while SignedPack seals them and signs the sealed bytes.
+*/
+func WithFieldEncryption(keys KeyProvider, policy MissingKeyPolicy) PackerOption {
+	return func(s *Packer) {
+		s.fieldKeys = keys
+		s.missingFieldKey = policy
+	}
+}
+
+func (s *Packer) encodeEncryptedField(f reflect.Value, fi fieldInfo) error {
+	if s.canonical && !s.signing {
+		return errors.New("encrypted fields have no canonical encoding")
+	}
+	if s.fieldKeys == nil {
+		return errors.New(fmt.Sprintf("field %s is tagged for encryption, but the packer has no field keys", fi.aad))
+	}
+	keyID, aead, err := s.fieldKeys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	plain, err := s.encodeScoped(func() error {
+		if fi.lazy {
+			return s.encodeLazy(f)
+		}
+		return s.encodeValue(f)
+	})
+	if err != nil {
+		return err
+	}
+
+	sealed := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	_, err = rand.Read(sealed)
+	if err != nil {
+		return err
+	}
+	sealed = aead.Seal(sealed, sealed, plain, fi.aad)
+	err = s.PackUint32(keyID)
+	if err != nil {
+		return err
+	}
+	err = s.PackInt32(int32(len(sealed)))
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(sealed)
+	return err
+}
+
+func (s *Packer) readEncryptedField(buf BPReader, f reflect.Value, fi fieldInfo) error {
+	keyID, err := s.UnpackUint32(buf)
+	if err != nil {
+		return err
+	}
+	sealedLen, err := s.UnpackInt32(buf)
+	if err != nil {
+		return err
+	}
+	if sealedLen < 0 {
+		return errors.New(fmt.Sprintf("invalid length %d of encrypted field %s", sealedLen, fi.aad))
+	}
+
+	keyErr := errors.New(fmt.Sprintf("%s for encrypted field %s", ErrUnknownKey, fi.aad))
+	if s.fieldKeys != nil {
+		aead, err := s.fieldKeys.Key(keyID)
+		if err == nil {
+			var sealed bytes.Buffer
+			_, err = io.CopyN(&sealed, buf, int64(sealedLen))
+			if err != nil {
+				return unexpectedEOF(err)
+			}
+			if sealed.Len() < aead.NonceSize() {
+				return ErrDecryptionFailed
+			}
+			nonce := sealed.Next(aead.NonceSize())
+			plain, err := aead.Open(nil, nonce, sealed.Bytes(), fi.aad)
+			if err != nil {
+				return ErrDecryptionFailed
+			}
+			return s.readScoped(plain, func(buf BPReader) error {
+				fi.encrypt = false
+				return s.readStructField(buf, f, fi)
+			})
+		}
+		keyErr = fmt.Errorf("%w for encrypted field %s", err, fi.aad)
+	}
+
+	if s.missingFieldKey == ErrorOnMissingKey {
+		if s.fieldKeys == nil {
+			return fmt.Errorf("%w for encrypted field %s, the packer has no field keys", ErrUnknownKey, fi.aad)
+		}
+		return keyErr
+	}
+	_, err = io.CopyN(io.Discard, buf, int64(sealedLen))
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	f.Set(reflect.Zero(f.Type()))
+	return nil
+}
+
+// encodeScoped runs encode against a separate buffer, with pointer identities of its own, and returns the bytes it wrote
+func (s *Packer) encodeScoped(encode func() error) ([]byte, error) {
+	out, ptrIdCounter, ptrstoid := s.w, s.ptrIdCounter, s.ptrstoid
+	scratch := new(encBuffer)
+	s.w, s.ptrIdCounter, s.ptrstoid = scratch, 1, make(map[uintptr]uint32, 0)
+	err := encode()
+	s.w, s.ptrIdCounter, s.ptrstoid = out, ptrIdCounter, ptrstoid
+	if err != nil {
+		return nil, err
+	}
+	return scratch.Bytes(), nil
+}
+
+// readScoped runs read against data produced by encodeScoped, with pointer identities of its own
+func (s *Packer) readScoped(data []byte, read func(buf BPReader) error) error {
+	idstoptr := s.idstoptr
+	s.idstoptr = make(map[uint32]*decodingPtr, 0)
+	defer func() {
+		s.idstoptr = idstoptr
+	}()
+	return read(newSliceReader(data))
+}
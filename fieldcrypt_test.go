@@ -0,0 +1,142 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type credentials struct {
+	User     string
+	Password string            `bytepack:"encrypt"`
+	Tokens   map[string]string `bytepack:"encrypt"`
+	Owner    *person           `bytepack:"encrypt"`
+	Shared   *person
+}
+
+func newTestCredentials() credentials {
+	owner := &person{Name: "Tester", Age: 30, Height: 5.25}
+	return credentials{
+		User:     "tester",
+		Password: "hunter2",
+		Tokens:   map[string]string{"api": "secret-token"},
+		Owner:    owner,
+		Shared:   owner,
+	}
+}
+
+func TestPacker_FieldEncryption(t *testing.T) {
+	s := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey))
+	c := newTestCredentials()
+
+	packed, err := s.Pack(c)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Contains(packed, []byte("tester")))
+	assert.False(t, bytes.Contains(packed, []byte("hunter2")))
+	assert.False(t, bytes.Contains(packed, []byte("secret-token")))
+
+	var c2 credentials
+	err = s.Unpack(packed, &c2)
+	assert.NoError(t, err)
+	assert.Equal(t, c, c2)
+	// the encrypted field has pointer identities of its own
+	assert.NotSame(t, c2.Owner, c2.Shared)
+}
+
+func TestPacker_FieldEncryptionKeyRotation(t *testing.T) {
+	packed, err := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey)).Pack(newTestCredentials())
+	assert.NoError(t, err)
+
+	var c credentials
+	err = NewPacker(WithFieldEncryption(newTestKeyRing(t, 1, 2), ErrorOnMissingKey)).Unpack(packed, &c)
+	assert.NoError(t, err)
+	assert.Equal(t, newTestCredentials(), c)
+}
+
+func TestPacker_FieldEncryptionMissingKey(t *testing.T) {
+	packed, err := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey)).Pack(newTestCredentials())
+	assert.NoError(t, err)
+
+	var c credentials
+	err = NewPacker(WithFieldEncryption(newTestKeyRing(t, 2), ZeroOnMissingKey)).Unpack(packed, &c)
+	assert.NoError(t, err)
+	assert.Equal(t, "tester", c.User)
+	assert.Equal(t, "", c.Password)
+	assert.Nil(t, c.Tokens)
+	assert.Nil(t, c.Owner)
+	assert.Equal(t, "Tester", c.Shared.Name)
+
+	var c2 credentials
+	err = NewPacker().Unpack(packed, &c2)
+	assert.NoError(t, err)
+	assert.Equal(t, "", c2.Password)
+	assert.Equal(t, "Tester", c2.Shared.Name)
+
+	var c3 credentials
+	err = NewPacker(WithFieldEncryption(newTestKeyRing(t, 2), ErrorOnMissingKey)).Unpack(packed, &c3)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	var c4 credentials
+	err = NewPacker(WithFieldEncryption(nil, ErrorOnMissingKey)).Unpack(packed, &c4)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestPacker_FieldEncryptionRejectsTampering(t *testing.T) {
+	type secret struct {
+		Value string `bytepack:"encrypt"`
+	}
+	s := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey))
+	packed, err := s.Pack(secret{Value: "hunter2"})
+	assert.NoError(t, err)
+
+	// root flag, key ID and sealed length come first
+	for i := 9; i < len(packed); i++ {
+		tampered := make([]byte, len(packed))
+		copy(tampered, packed)
+		tampered[i] ^= 0x10
+
+		var sec secret
+		err = s.Unpack(tampered, &sec)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	}
+}
+
+func TestPacker_FieldEncryptionBoundToField(t *testing.T) {
+	type pair struct {
+		A string `bytepack:"encrypt"`
+		B string `bytepack:"encrypt"`
+	}
+	s := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey))
+	packed, err := s.Pack(pair{A: "first", B: "second"})
+	assert.NoError(t, err)
+
+	// swap the two sealed fields, which have the same length
+	fieldLen := (len(packed) - 1) / 2
+	swapped := []byte{packed[0]}
+	swapped = append(swapped, packed[1+fieldLen:]...)
+	swapped = append(swapped, packed[1:1+fieldLen]...)
+
+	var p pair
+	err = s.Unpack(swapped, &p)
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+}
+
+func TestPacker_FieldEncryptionErrors(t *testing.T) {
+	_, err := NewPacker().Pack(newTestCredentials())
+	assert.Error(t, err)
+
+	_, err = NewPacker(WithCanonical(), WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey)).Pack(newTestCredentials())
+	assert.Error(t, err)
+}
+
+func TestBytePack_FieldEncryption(t *testing.T) {
+	bp := NewBytePack(2, WithPackerOptions(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey)))
+	c := newTestCredentials()
+	packed, err := bp.Pack(c)
+	assert.NoError(t, err)
+
+	var c2 credentials
+	err = bp.UnpackFromReader(bytes.NewBuffer(packed), &c2)
+	assert.NoError(t, err)
+	assert.Equal(t, c, c2)
+}
@@ -0,0 +1,68 @@
+package bytepack
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"sync"
+)
+
+// sinkFlushSize is how many bytes the packer accumulates before handing them to the sink
+const sinkFlushSize = 4096
+
+var hashPackers = sync.Pool{
+	New: func() interface{} {
+		return NewPacker(WithCanonical())
+	},
+}
+
+// Hash
+/*
+   Hash writes the canonical encoding of v into h, without building the complete encoding in memory.
+   The resulting digest does not depend on map iteration order or on the platform, so it can be compared across processes.
+*/
+func Hash(v interface{}, h hash.Hash) error {
+	s := hashPackers.Get().(*Packer)
+	defer hashPackers.Put(s)
+	return s.packTo(v, h)
+}
+
+// Sum256 returns the SHA-256 digest of the canonical encoding of v
+func Sum256(v interface{}) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	err := Hash(v, h)
+	if err != nil {
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// packTo encodes obj like Pack does, but streams the encoding into w as it goes
+func (s *Packer) packTo(obj interface{}, w io.Writer) error {
+	s.resetEncoder()
+	s.sink = w
+	s.sinkBuf = s.w
+	defer func() {
+		s.sink = nil
+		s.sinkBuf = nil
+		s.w.Reset()
+	}()
+	err := s.encode(obj)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.WriteTo(w)
+	return err
+}
+
+// flushSink hands the encoded bytes to the sink once enough of them accumulate.
+// Bytes written to a scratch buffer, such as map keys being sorted, are never flushed.
+func (s *Packer) flushSink() error {
+	if s.sink == nil || s.w != s.sinkBuf || s.w.Len() < sinkFlushSize {
+		return nil
+	}
+	_, err := s.w.WriteTo(s.sink)
+	return err
+}
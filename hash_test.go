@@ -0,0 +1,73 @@
+package bytepack
+
+import (
+	"crypto/sha256"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+type hashLogEntry struct {
+	Slot     int64
+	Command  []byte
+	Votes    map[string]bool
+	Children []person
+}
+
+func newHashLogEntry(n int) hashLogEntry {
+	e := hashLogEntry{
+		Slot:    42,
+		Command: make([]byte, n),
+		Votes:   make(map[string]bool),
+	}
+	for i := 0; i < n; i++ {
+		e.Command[i] = byte(i)
+		e.Votes["replica"+strconv.Itoa(i)] = i%2 == 0
+		e.Children = append(e.Children, person{Name: "child" + strconv.Itoa(i), Age: int32(i)})
+	}
+	return e
+}
+
+func TestHash_MatchesCanonicalEncoding(t *testing.T) {
+	// large enough for the encoding to be streamed in several chunks
+	e := newHashLogEntry(2000)
+
+	canonical, err := NewPacker(WithCanonical()).Pack(e)
+	assert.NoError(t, err)
+	assert.Greater(t, len(canonical), 2*sinkFlushSize)
+
+	sum, err := Sum256(e)
+	assert.NoError(t, err)
+	assert.Equal(t, sha256.Sum256(canonical), sum)
+}
+
+func TestHash_IsStable(t *testing.T) {
+	sum1, err := Sum256(newHashLogEntry(100))
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		sum2, err := Sum256(newHashLogEntry(100))
+		assert.NoError(t, err)
+		assert.Equal(t, sum1, sum2)
+	}
+
+	e := newHashLogEntry(100)
+	e.Votes["replica1"] = true
+	sum3, err := Sum256(e)
+	assert.NoError(t, err)
+	assert.NotEqual(t, sum1, sum3)
+}
+
+func TestHash_Error(t *testing.T) {
+	type fooFunc struct {
+		F func()
+	}
+	_, err := Sum256(fooFunc{})
+	assert.ErrorIs(t, err, ErrUnsupportedKind)
+
+	// the pooled packer is left clean after an error
+	sum, err := Sum256(newHashLogEntry(10))
+	assert.NoError(t, err)
+	canonical, err := NewPacker(WithCanonical()).Pack(newHashLogEntry(10))
+	assert.NoError(t, err)
+	assert.Equal(t, sha256.Sum256(canonical), sum)
+}
@@ -0,0 +1,274 @@
+package bytepack
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// Writer
+/*
+   Writer helps hand-written Pack methods: it wraps the Pack helpers of a Packer with a sticky error.
+   After the first failure every call does nothing, so the error only has to be checked once, with Err, at the end:
+
+       w := bytepack.NewWriter(packer)
+       w.Float64(p.Height)
+       w.String(p.Name)
+       return w.Err()
+*/
+type Writer struct {
+	p   *Packer
+	err error
+}
+
+func NewWriter(p *Packer) *Writer {
+	return &Writer{p: p}
+}
+
+// Err returns the first error the Writer ran into
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) String(str string) {
+	if w.err == nil {
+		w.err = w.p.PackString(str)
+	}
+}
+
+func (w *Writer) Bytes(b []byte) {
+	if w.err == nil {
+		w.err = w.p.PackBytes(b)
+	}
+}
+
+func (w *Writer) Int(ival int) {
+	if w.err == nil {
+		w.err = w.p.PackInt(ival)
+	}
+}
+
+func (w *Writer) Int8(ival int8) {
+	if w.err == nil {
+		w.err = w.p.PackInt8(ival)
+	}
+}
+
+func (w *Writer) Int16(ival int16) {
+	if w.err == nil {
+		w.err = w.p.PackInt16(ival)
+	}
+}
+
+func (w *Writer) Int32(ival int32) {
+	if w.err == nil {
+		w.err = w.p.PackInt32(ival)
+	}
+}
+
+func (w *Writer) Int64(ival int64) {
+	if w.err == nil {
+		w.err = w.p.PackInt64(ival)
+	}
+}
+
+func (w *Writer) Uint(uival uint) {
+	if w.err == nil {
+		w.err = w.p.PackUint(uival)
+	}
+}
+
+func (w *Writer) Uint8(uival uint8) {
+	if w.err == nil {
+		w.err = w.p.PackUint8(uival)
+	}
+}
+
+func (w *Writer) Uint16(uival uint16) {
+	if w.err == nil {
+		w.err = w.p.PackUint16(uival)
+	}
+}
+
+func (w *Writer) Uint32(uival uint32) {
+	if w.err == nil {
+		w.err = w.p.PackUint32(uival)
+	}
+}
+
+func (w *Writer) Uint64(uival uint64) {
+	if w.err == nil {
+		w.err = w.p.PackUint64(uival)
+	}
+}
+
+func (w *Writer) Uintptr(uival uintptr) {
+	if w.err == nil {
+		w.err = w.p.PackUintptr(uival)
+	}
+}
+
+func (w *Writer) Float32(fval float32) {
+	if w.err == nil {
+		w.err = w.p.PackFloat32(fval)
+	}
+}
+
+func (w *Writer) Float64(fval float64) {
+	if w.err == nil {
+		w.err = w.p.PackFloat64(fval)
+	}
+}
+
+func (w *Writer) Complex64(cval complex64) {
+	if w.err == nil {
+		w.err = w.p.PackComplex64(cval)
+	}
+}
+
+func (w *Writer) Complex128(cval complex128) {
+	if w.err == nil {
+		w.err = w.p.PackComplex128(cval)
+	}
+}
+
+func (w *Writer) Bool(bval bool) {
+	if w.err == nil {
+		w.err = w.p.PackBool(bval)
+	}
+}
+
+func (w *Writer) Varint(ival int64) {
+	if w.err == nil {
+		w.err = w.p.PackVarint(ival)
+	}
+}
+
+func (w *Writer) Uvarint(uival uint64) {
+	if w.err == nil {
+		w.err = w.p.PackUvarint(uival)
+	}
+}
+
+func (w *Writer) Time(t time.Time) {
+	if w.err == nil {
+		w.err = w.p.PackTime(t)
+	}
+}
+
+func (w *Writer) Struct(obj interface{}) {
+	if w.err == nil {
+		w.err = w.p.PackStruct(obj)
+	}
+}
+
+func (w *Writer) Slice(slice interface{}) {
+	if w.err == nil {
+		w.err = w.p.PackSlice(slice)
+	}
+}
+
+func (w *Writer) Map(m interface{}) {
+	if w.err == nil {
+		w.err = w.p.PackMap(m)
+	}
+}
+
+// Reader
+/*
+   Reader is the counterpart of Writer for hand-written Unpack methods. Once a read fails,
+   every later read returns the zero value and Err returns the first error:
+
+       r := bytepack.NewReader(packer, buf)
+       p.Height = r.Float64()
+       p.Name = r.String()
+       return r.Err()
+*/
+type Reader struct {
+	p   *Packer
+	buf BPReader
+	err error
+}
+
+func NewReader(p *Packer, buf BPReader) *Reader {
+	return &Reader{p: p, buf: buf}
+}
+
+// Err returns the first error the Reader ran into
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// read runs unpack unless an earlier read failed, and records its error
+func read[T any](r *Reader, unpack func(buf BPReader) (T, error)) T {
+	var zero T
+	if r.err != nil {
+		return zero
+	}
+	v, err := unpack(r.buf)
+	if err != nil {
+		r.err = err
+		return zero
+	}
+	return v
+}
+
+func (r *Reader) String() string         { return read(r, r.p.UnpackString) }
+func (r *Reader) Bytes() []byte          { return read(r, r.p.UnpackBytes) }
+func (r *Reader) Int() int               { return read(r, r.p.UnpackInt) }
+func (r *Reader) Int8() int8             { return read(r, r.p.UnpackInt8) }
+func (r *Reader) Int16() int16           { return read(r, r.p.UnpackInt16) }
+func (r *Reader) Int32() int32           { return read(r, r.p.UnpackInt32) }
+func (r *Reader) Int64() int64           { return read(r, r.p.UnpackInt64) }
+func (r *Reader) Uint() uint             { return read(r, r.p.UnpackUint) }
+func (r *Reader) Uint8() uint8           { return read(r, r.p.UnpackUint8) }
+func (r *Reader) Uint16() uint16         { return read(r, r.p.UnpackUint16) }
+func (r *Reader) Uint32() uint32         { return read(r, r.p.UnpackUint32) }
+func (r *Reader) Uint64() uint64         { return read(r, r.p.UnpackUint64) }
+func (r *Reader) Uintptr() uintptr       { return read(r, r.p.UnpackUintptr) }
+func (r *Reader) Float32() float32       { return read(r, r.p.UnpackFloat32) }
+func (r *Reader) Float64() float64       { return read(r, r.p.UnpackFloat64) }
+func (r *Reader) Complex64() complex64   { return read(r, r.p.UnpackComplex64) }
+func (r *Reader) Complex128() complex128 { return read(r, r.p.UnpackComplex128) }
+func (r *Reader) Bool() bool             { return read(r, r.p.UnpackBool) }
+func (r *Reader) Varint() int64          { return read(r, r.p.UnpackVarint) }
+func (r *Reader) Uvarint() uint64        { return read(r, r.p.UnpackUvarint) }
+func (r *Reader) Time() time.Time        { return read(r, r.p.UnpackTime) }
+
+// Struct reads a struct into the value obj points to, like UnpackStruct does
+func (r *Reader) Struct(obj interface{}) {
+	if r.err == nil {
+		r.err = r.p.UnpackStruct(r.buf, obj)
+	}
+}
+
+// Slice reads a slice into the slice ptr points to
+func (r *Reader) Slice(ptr interface{}) {
+	r.into(ptr, reflect.Slice, r.p.UnpackSlice)
+}
+
+// Map reads a map into the map ptr points to
+func (r *Reader) Map(ptr interface{}) {
+	r.into(ptr, reflect.Map, r.p.UnpackMap)
+}
+
+func (r *Reader) into(ptr interface{}, kind reflect.Kind, unpack func(t reflect.Type, buf BPReader) (*reflect.Value, error)) {
+	if r.err != nil {
+		return
+	}
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != kind {
+		r.err = errors.New("expect a pointer to a " + kind.String())
+		return
+	}
+	val, err := unpack(v.Elem().Type(), r.buf)
+	if err != nil {
+		r.err = err
+		return
+	}
+	if val == nil {
+		v.Elem().Set(reflect.Zero(v.Elem().Type()))
+		return
+	}
+	v.Elem().Set(*val)
+}
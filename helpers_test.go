@@ -0,0 +1,198 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"runtime"
+	"testing"
+	"time"
+)
+
+type auditRecord struct {
+	Height  float64
+	Name    string
+	Age     int32
+	Payload []byte
+	Seq     int64
+	Offset  uint64
+	At      time.Time
+	Tags    []string
+	Scores  map[string]int
+	Owner   person
+}
+
+func (a *auditRecord) Pack(packer *Packer) error {
+	w := NewWriter(packer)
+	w.Float64(a.Height)
+	w.String(a.Name)
+	w.Int32(a.Age)
+	w.Bytes(a.Payload)
+	w.Varint(a.Seq)
+	w.Uvarint(a.Offset)
+	w.Time(a.At)
+	w.Slice(a.Tags)
+	w.Map(a.Scores)
+	w.Struct(&a.Owner)
+	return w.Err()
+}
+
+func (a *auditRecord) Unpack(packer *Packer, buf BPReader) error {
+	r := NewReader(packer, buf)
+	a.Height = r.Float64()
+	a.Name = r.String()
+	a.Age = r.Int32()
+	a.Payload = r.Bytes()
+	a.Seq = r.Varint()
+	a.Offset = r.Uvarint()
+	a.At = r.Time()
+	r.Slice(&a.Tags)
+	r.Map(&a.Scores)
+	r.Struct(&a.Owner)
+	return r.Err()
+}
+
+func newAuditRecord() auditRecord {
+	return auditRecord{
+		Height:  5.25,
+		Name:    "Tester",
+		Age:     30,
+		Payload: []byte{1, 2, 3},
+		Seq:     -300,
+		Offset:  math.MaxUint64,
+		At:      time.Date(2024, 3, 5, 10, 30, 15, 123456789, time.FixedZone("", -5*3600)),
+		Tags:    []string{"a", "b"},
+		Scores:  map[string]int{"x": 1},
+		Owner:   person{Name: "Owner", Age: 40, Height: 6},
+	}
+}
+
+func TestPacker_WriterAndReader(t *testing.T) {
+	s := NewPacker()
+	a := newAuditRecord()
+	buf, err := s.Pack(&a)
+	assert.NoError(t, err)
+
+	var fromSlice, fromStream auditRecord
+	assert.NoError(t, s.Unpack(buf, &fromSlice))
+	assert.NoError(t, s.UnpackFromReader(bytes.NewBuffer(buf), &fromStream))
+	for _, a2 := range []auditRecord{fromSlice, fromStream} {
+		assert.True(t, a.At.Equal(a2.At))
+		_, offset := a2.At.Zone()
+		assert.Equal(t, -5*3600, offset)
+		a2.At = a.At
+		assert.Equal(t, a, a2)
+	}
+}
+
+func TestPacker_ReaderErrorIsSticky(t *testing.T) {
+	s := NewPacker()
+	a := newAuditRecord()
+	buf, err := s.Pack(&a)
+	assert.NoError(t, err)
+
+	var a2 auditRecord
+	err = s.Unpack(buf[:10], &a2)
+	assert.Error(t, err)
+	assert.Equal(t, 5.25, a2.Height)
+	assert.Equal(t, "", a2.Name)
+	assert.Nil(t, a2.Tags)
+
+	r := NewReader(s, bytes.NewBuffer(nil))
+	assert.Equal(t, int64(0), r.Int64())
+	assert.Equal(t, io.EOF, r.Err())
+	assert.Equal(t, "", r.String())
+	assert.Equal(t, io.EOF, r.Err())
+}
+
+func TestPacker_WriterErrorIsSticky(t *testing.T) {
+	s := NewPacker()
+	w := NewWriter(s)
+	w.Int32(1)
+	w.Slice("not a slice")
+	w.Int32(2)
+	assert.Error(t, w.Err())
+	assert.Equal(t, 4, s.w.Len())
+}
+
+func TestPacker_PackBytesMatchesByteSliceField(t *testing.T) {
+	for _, b := range [][]byte{nil, {}, {1, 2, 3}} {
+		s := NewPacker()
+		assert.NoError(t, s.PackBytes(b))
+		helper := append([]byte(nil), s.w.Bytes()...)
+		s.w.Reset()
+		assert.NoError(t, s.PackSlice(b))
+		assert.Equal(t, s.w.Bytes(), helper)
+
+		b2, err := s.UnpackBytes(newSliceReader(helper))
+		assert.NoError(t, err)
+		assert.Equal(t, b, b2)
+	}
+}
+
+func TestPacker_UnpackBytesWithCorruptedLength(t *testing.T) {
+	s := NewPacker()
+	// a length of 2 GB followed by 3 bytes
+	data := []byte{0, 0x7F, 0xFF, 0xFF, 0xFF, 1, 2, 3}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := s.UnpackBytes(newSliceReader(data))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = s.UnpackBytes(bytes.NewBuffer(data))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+func TestPacker_PackVarint(t *testing.T) {
+	s := NewPacker()
+	values := []int64{0, 1, -1, 63, -64, 64, math.MaxInt64, math.MinInt64}
+	for _, v := range values {
+		assert.NoError(t, s.PackVarint(v))
+	}
+	assert.Equal(t, []byte{0, 2, 1, 0x7e, 0x7f}, s.w.Bytes()[:5])
+
+	for _, buf := range []BPReader{newSliceReader(s.w.Bytes()), bytes.NewBuffer(s.w.Bytes())} {
+		for _, v := range values {
+			v2, err := s.UnpackVarint(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, v, v2)
+		}
+	}
+
+	_, err := s.UnpackUvarint(newSliceReader([]byte{0x80}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = s.UnpackUvarint(newSliceReader(bytes.Repeat([]byte{0xff}, 11)))
+	assert.Error(t, err)
+}
+
+func TestPacker_PackTime(t *testing.T) {
+	s := NewPacker()
+	times := []time.Time{
+		{},
+		time.Date(2024, 3, 5, 10, 30, 15, 999999999, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 1, time.FixedZone("", 5*3600+30*60)),
+		time.Now(),
+	}
+	for _, tm := range times {
+		assert.NoError(t, s.PackTime(tm))
+	}
+	buf := newSliceReader(s.w.Bytes())
+	for _, tm := range times {
+		tm2, err := s.UnpackTime(buf)
+		assert.NoError(t, err)
+		assert.True(t, tm.Equal(tm2), "%v != %v", tm, tm2)
+		_, offset := tm.Zone()
+		_, offset2 := tm2.Zone()
+		assert.Equal(t, offset, offset2)
+	}
+	assert.True(t, times[1] == func() time.Time {
+		s.w.Reset()
+		assert.NoError(t, s.PackTime(times[1]))
+		tm, _ := s.UnpackTime(newSliceReader(s.w.Bytes()))
+		return tm
+	}())
+
+	assert.Error(t, s.PackTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("", 30))))
+}
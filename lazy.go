@@ -0,0 +1,168 @@
+package bytepack
+
+import (
+	"reflect"
+)
+
+// Lazy
+/*
+   Lazy is a struct field holding a value of type T that is only decoded when Get is first called.
+   When a struct is unpacked, its Lazy fields capture the encoded bytes of their values, and when the struct
+   is packed again, the same bytes are written back, unless the value was replaced with Set.
+   Values returned by Get must therefore not be modified in place.
+
+   A Lazy is encoded like a Raw holding its packed value, so the two can be used interchangeably on the wire.
+*/
+type Lazy[T any] struct {
+	raw []byte
+	// packer decodes raw with the configuration of the packer that captured it
+	packer  *Packer
+	value   T
+	decoded bool
+	err     error
+}
+
+// lazyField lets the packer handle Lazy fields, whatever their type parameter
+type lazyField interface {
+	lazyElem() reflect.Type
+	lazyPayload(s *Packer) ([]byte, error)
+	lazySize(s *Packer) (int, error)
+}
+
+// lazyCapture is implemented by pointers to Lazy fields
+type lazyCapture interface {
+	captureLazy(s *Packer, raw []byte)
+}
+
+var lazyFieldType = reflect.TypeOf((*lazyField)(nil)).Elem()
+var bytesType = reflect.TypeOf([]byte(nil))
+
+// NewLazy returns a Lazy holding v
+func NewLazy[T any](v T) Lazy[T] {
+	return Lazy[T]{value: v, decoded: true}
+}
+
+// Get returns the value, decoding it on the first call. A decoding error is returned by every call.
+func (l *Lazy[T]) Get() (T, error) {
+	if !l.decoded && l.raw != nil {
+		l.value, l.err = l.decode()
+		l.decoded = true
+		l.packer = nil
+	}
+	return l.value, l.err
+}
+
+// Set replaces the value, which is then packed anew
+func (l *Lazy[T]) Set(v T) {
+	*l = NewLazy(v)
+}
+
+// IsSet reports whether the Lazy holds a value. It does not when it was unpacked from a zero Lazy.
+func (l *Lazy[T]) IsSet() bool {
+	return l.raw != nil || l.decoded && !isNilPointer(l.value)
+}
+
+func (l Lazy[T]) decode() (T, error) {
+	var v T
+	ptr := reflect.ValueOf(&v)
+	if ptr.Elem().Kind() == reflect.Ptr {
+		// values packed through a pointer are unpacked into a newly allocated one
+		ptr.Elem().Set(reflect.New(ptr.Elem().Type().Elem()))
+		ptr = ptr.Elem()
+	}
+	err := l.packer.Unpack(l.raw, ptr.Interface())
+	return v, err
+}
+
+func (l Lazy[T]) lazyElem() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// lazyPayload returns the packed value, or nil if there is no value
+func (l Lazy[T]) lazyPayload(s *Packer) ([]byte, error) {
+	v := l.value
+	if l.raw != nil {
+		if !s.canonical {
+			return l.raw, nil
+		}
+		// the captured bytes may not be canonical, so pack the value anew
+		err := l.err
+		if !l.decoded {
+			v, err = l.decode()
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else if !l.decoded || isNilPointer(v) {
+		return nil, nil
+	}
+	return s.derive().Pack(v)
+}
+
+// lazySize returns the size of the payload lazyPayload would return, or -1 if there is no value
+func (l Lazy[T]) lazySize(s *Packer) (int, error) {
+	v := l.value
+	if l.raw != nil {
+		if !s.canonical {
+			return len(l.raw), nil
+		}
+		err := l.err
+		if !l.decoded {
+			v, err = l.decode()
+		}
+		if err != nil {
+			return 0, err
+		}
+	} else if !l.decoded || isNilPointer(v) {
+		return -1, nil
+	}
+	return s.derive().Size(v)
+}
+
+func (l *Lazy[T]) captureLazy(s *Packer, raw []byte) {
+	*l = Lazy[T]{}
+	if raw != nil {
+		l.raw = raw
+		l.packer = s.derive()
+	}
+}
+
+func isNilPointer(v interface{}) bool {
+	val := reflect.ValueOf(v)
+	return !val.IsValid() || val.Kind() == reflect.Ptr && val.IsNil()
+}
+
+func (s *Packer) encodeLazy(f reflect.Value) error {
+	payload, err := f.Interface().(lazyField).lazyPayload(s)
+	if err != nil {
+		return err
+	}
+	return s.encodeSlice(reflect.ValueOf(payload))
+}
+
+func (s *Packer) readLazy(buf BPReader, f reflect.Value) error {
+	raw, err := s.UnpackSlice(bytesType, buf)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	if raw != nil {
+		payload = raw.Bytes()
+	}
+	f.Addr().Interface().(lazyCapture).captureLazy(s, payload)
+	return nil
+}
+
+// derive returns a packer with the configuration of s and a state of its own
+func (s *Packer) derive() *Packer {
+	d := *s
+	d.w = new(encBuffer)
+	d.sink, d.sinkBuf = nil, nil
+	d.rootPtrEncoded = false
+	d.ptrIdCounter = 0
+	d.ptrstoid, d.idstoptr, d.claimedPtrs = nil, nil, nil
+	d.scratch, d.sizeEstimates = nil, nil
+	d.frames, d.depth, d.nested, d.stackBase = nil, 0, 0, 0
+	d.spareEntries = nil
+	return &d
+}
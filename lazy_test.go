@@ -0,0 +1,138 @@
+package bytepack
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+type heartbeat struct {
+	Term     int
+	Snapshot Lazy[snapshotMsg]
+	Leader   Lazy[*person]
+}
+
+func TestPacker_Lazy(t *testing.T) {
+	s := NewPacker()
+	snapshot := newSnapshotMsg(10)
+	hb := heartbeat{Term: 3, Snapshot: NewLazy(snapshot), Leader: NewLazy(&person{Name: "Leader"})}
+
+	packed, err := s.Pack(hb)
+	assert.NoError(t, err)
+
+	var hb2 heartbeat
+	err = s.Unpack(packed, &hb2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, hb2.Term)
+	assert.True(t, hb2.Snapshot.IsSet())
+
+	snapshot2, err := hb2.Snapshot.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot, snapshot2)
+	leader, err := hb2.Leader.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, &person{Name: "Leader"}, leader)
+
+	// unchanged values are packed from the captured bytes
+	repacked, err := s.Pack(hb2)
+	assert.NoError(t, err)
+	assert.Equal(t, packed, repacked)
+}
+
+func TestPacker_LazySet(t *testing.T) {
+	s := NewPacker()
+	packed, err := s.Pack(heartbeat{Term: 3, Leader: NewLazy(&person{Name: "Leader"})})
+	assert.NoError(t, err)
+
+	var hb heartbeat
+	err = s.Unpack(packed, &hb)
+	assert.NoError(t, err)
+	assert.False(t, hb.Snapshot.IsSet())
+	snapshot, err := hb.Snapshot.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, snapshotMsg{}, snapshot)
+	assert.False(t, hb.Snapshot.IsSet())
+
+	hb.Leader.Set(&person{Name: "New Leader"})
+	packed, err = s.Pack(&hb)
+	assert.NoError(t, err)
+	var hb2 heartbeat
+	err = s.Unpack(packed, &hb2)
+	assert.NoError(t, err)
+	leader, err := hb2.Leader.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "New Leader", leader.Name)
+}
+
+func TestPacker_LazyDecodingError(t *testing.T) {
+	type rawHeartbeat struct {
+		Term     int
+		Snapshot Raw
+		Leader   Raw
+	}
+	s := NewPacker()
+	packed, err := s.Pack(rawHeartbeat{Term: 3, Snapshot: Raw{0, 1, 2}})
+	assert.NoError(t, err)
+
+	var hb heartbeat
+	err = s.Unpack(packed, &hb)
+	assert.NoError(t, err)
+	_, err = hb.Snapshot.Get()
+	assert.Error(t, err)
+	_, err = hb.Snapshot.Get()
+	assert.Error(t, err)
+
+	// the bytes are still forwarded as they came
+	repacked, err := s.Pack(hb)
+	assert.NoError(t, err)
+	var hb2 rawHeartbeat
+	err = s.Unpack(repacked, &hb2)
+	assert.NoError(t, err)
+	assert.Equal(t, Raw{0, 1, 2}, hb2.Snapshot)
+}
+
+func TestPacker_LazyWithOtherFeatures(t *testing.T) {
+	s := NewPacker()
+	packed, err := s.Pack(heartbeat{Term: 3, Snapshot: NewLazy(newSnapshotMsg(10))})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Validate(packed, reflect.TypeOf(heartbeat{})))
+
+	var hb heartbeat
+	err = s.UnpackFields(packed, &hb, "Term", "Snapshot")
+	assert.NoError(t, err)
+	snapshot, err := hb.Snapshot.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, newSnapshotMsg(10), snapshot)
+
+	// the hash does not depend on whether the snapshot was decoded
+	sum1, err := Sum256(heartbeat{Term: 3, Snapshot: NewLazy(newSnapshotMsg(10))})
+	assert.NoError(t, err)
+	sum2, err := Sum256(hb)
+	assert.NoError(t, err)
+	assert.Equal(t, sum1, sum2)
+}
+
+func TestPacker_LazyOnlyAsField(t *testing.T) {
+	s := NewPacker()
+	_, err := s.Pack(NewLazy(3))
+	assert.Error(t, err)
+	_, err = s.Pack(struct{ L []Lazy[int] }{})
+	assert.Error(t, err)
+}
+
+func TestPacker_LazyEncrypted(t *testing.T) {
+	type secretHeartbeat struct {
+		Term     int
+		Snapshot Lazy[snapshotMsg] `bytepack:"encrypt"`
+	}
+	s := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey))
+	packed, err := s.Pack(secretHeartbeat{Term: 3, Snapshot: NewLazy(newSnapshotMsg(10))})
+	assert.NoError(t, err)
+
+	var hb secretHeartbeat
+	err = s.Unpack(packed, &hb)
+	assert.NoError(t, err)
+	snapshot, err := hb.Snapshot.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, newSnapshotMsg(10), snapshot)
+}
@@ -0,0 +1,275 @@
+package bytepack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// MergePolicy
+/*
+   MergePolicy controls how UnpackInto combines a message with the slices and maps already in the target.
+   Policies can be combined.
+*/
+type MergePolicy uint8
+
+const (
+	// MergeOverwrite replaces slices and maps with the ones in the message, reusing their memory
+	MergeOverwrite MergePolicy = 0
+	// MergeAppendSlices appends elements of slices in the message to the existing slices
+	MergeAppendSlices MergePolicy = 1 << iota
+	// MergeUnionMaps adds entries of maps in the message to the existing maps, replacing entries with the same key
+	MergeUnionMaps
+)
+
+// UnpackInto
+/*
+   UnpackInto decodes data into the value obj points to, reusing the memory the value already holds:
+   slices keep their capacity, maps are cleared and refilled, and pointers are decoded into the values they
+   already point to. It is meant for long-lived message objects that are decoded into over and over.
+   Fields that are not in the message, such as unexported fields, keep their values.
+*/
+func (s *Packer) UnpackInto(data []byte, obj interface{}, policy MergePolicy) error {
+	if _, ok := obj.(Packable); ok {
+		return s.Unpack(data, obj)
+	}
+	s.idstoptr = make(map[uint32]*decodingPtr, 0)
+	if s.claimedPtrs == nil {
+		s.claimedPtrs = make(map[uintptr]bool)
+	}
+	for ptr := range s.claimedPtrs {
+		delete(s.claimedPtrs, ptr)
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("must pass a pointer to an object")
+	}
+	err := s.checkType(v.Elem().Type())
+	if err != nil {
+		return err
+	}
+
+	buf := newSliceReader(data)
+	switch v.Elem().Kind() {
+	case reflect.Struct:
+		notNil, err := s.readRootHeader(buf, v)
+		if err != nil || !notNil {
+			return err
+		}
+		s.claimedPtrs[v.Pointer()] = true
+		return s.readInto(buf, v.Elem(), policy)
+	case reflect.Interface, reflect.Ptr, reflect.Chan:
+		return fmt.Errorf("cannot unpack this type")
+	default:
+		return s.readInto(buf, v.Elem(), policy)
+	}
+}
+
+// readInto decodes a value in place, into the addressable v
+func (s *Packer) readInto(buf BPReader, v reflect.Value, policy MergePolicy) error {
+	base := len(s.frames)
+	err := s.readItemInto(buf, v, policy)
+	if err != nil || len(s.frames) == base {
+		return err
+	}
+	return s.readFramesInto(buf, base, policy)
+}
+
+// readFramesInto decodes the values held by the frames above base in place
+func (s *Packer) readFramesInto(buf BPReader, base int, policy MergePolicy) error {
+	if !s.beginWalk() {
+		return s.onFreshStack(func() error {
+			return s.readFramesInto(buf, base, policy)
+		})
+	}
+	defer s.endWalk(base)
+	for len(s.frames) > base {
+		f := s.top()
+		var err error
+		switch f.kind {
+		case structFrame:
+			if f.i == len(f.fields) {
+				s.pop()
+				continue
+			}
+			fi := f.fields[f.i]
+			f.i++
+			if fi.encrypt || fi.lazy {
+				err = s.readStructField(buf, s.field(f.v, fi), fi)
+			} else {
+				err = s.readItemInto(buf, s.field(f.v, fi), policy)
+			}
+		case elemsFrame:
+			if f.i == f.n {
+				s.pop()
+				continue
+			}
+			elem := f.v.Index(f.i)
+			f.i++
+			if f.fields != nil {
+				err = s.pushStruct(elem, f.fields)
+			} else {
+				err = s.readItemInto(buf, elem, policy)
+			}
+		case valueFrame:
+			if f.i == 1 {
+				s.pop()
+				continue
+			}
+			f.i = 1
+			err = s.readItemInto(buf, f.elem, policy)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readItemInto decodes v in place, or if v holds other values, decodes its header and pushes a frame for them
+func (s *Packer) readItemInto(buf BPReader, v reflect.Value, policy MergePolicy) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		return s.pushStruct(v, s.structFields(v.Type()))
+	case reflect.Ptr:
+		return s.readPointerInto(buf, v)
+	case reflect.Slice:
+		return s.readSliceInto(buf, v, policy)
+	case reflect.Array:
+		return s.readElementsInto(buf, v, 0)
+	case reflect.Map:
+		return s.readMapInto(buf, v, policy)
+	case reflect.Interface:
+		// interfaces are decoded into new values, with a traversal of their own
+		return s.readValue(buf, v)
+	case reflect.Chan:
+		// do nothing with the chan
+		return nil
+	default:
+		// basic kinds are set in place, like struct fields
+		return s.readItem(buf, v)
+	}
+}
+
+func (s *Packer) readPointerInto(buf BPReader, v reflect.Value) error {
+	ptrId, isNil, err := s.unpackPointerHeader(buf)
+	if err != nil {
+		return err
+	}
+	if isNil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if dp := s.idstoptr[ptrId]; dp != nil {
+		if dp.isDecoded {
+			v.Set(dp.ptr)
+		}
+		return nil
+	}
+
+	// a value the target already points to is reused, unless another pointer of the message took it
+	var ptr reflect.Value
+	if v.IsNil() || s.claimedPtrs[v.Pointer()] {
+		ptr = reflect.New(v.Type().Elem())
+	} else {
+		ptr = v.Elem().Addr()
+	}
+	s.claimedPtrs[ptr.Pointer()] = true
+	s.idstoptr[ptrId] = &decodingPtr{
+		isDecoded: true,
+		ptr:       ptr,
+	}
+	v.Set(ptr)
+	return s.push(frame{kind: valueFrame, elem: ptr.Elem()})
+}
+
+func (s *Packer) readSliceInto(buf BPReader, v reflect.Value, policy MergePolicy) error {
+	numEntries, isNil, _, err := s.readCollectionHeader(buf, v.Type())
+	if err != nil {
+		return err
+	}
+	if isNil {
+		if policy&MergeAppendSlices == 0 {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	from := 0
+	if policy&MergeAppendSlices != 0 {
+		from = v.Len()
+	}
+	newLen := from + numEntries
+	if v.IsNil() || newLen > v.Cap() {
+		newCap := newLen
+		if policy&MergeAppendSlices != 0 && 2*v.Cap() > newCap {
+			newCap = 2 * v.Cap()
+		}
+		grown := reflect.MakeSlice(v.Type(), newLen, newCap)
+		reflect.Copy(grown, v.Slice(0, from))
+		v.Set(grown)
+	} else {
+		oldLen := v.Len()
+		v.SetLen(newLen)
+		if s.wireSize(v.Type().Elem().Kind()) == 0 {
+			// elements past the old length may still hold values of earlier messages
+			for i := oldLen; i < newLen; i++ {
+				v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+			}
+		}
+	}
+	return s.readElementsInto(buf, v, from)
+}
+
+// readElementsInto decodes the elements of slice or array v, starting at index from
+func (s *Packer) readElementsInto(buf BPReader, v reflect.Value, from int) error {
+	elemKind := v.Type().Elem().Kind()
+	if !s.bulkKind(elemKind) {
+		return s.push(frame{kind: elemsFrame, v: v, i: from, n: v.Len(), fields: s.elemFields(v.Type().Elem())})
+	}
+	err := s.reach()
+	if err != nil {
+		return err
+	}
+	if elemKind != reflect.String {
+		return s.readFixed(buf, v.Slice(from, v.Len()))
+	}
+	for i := from; i < v.Len(); i++ {
+		err = s.readItem(buf, v.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Packer) readMapInto(buf BPReader, v reflect.Value, policy MergePolicy) error {
+	numEntries, isNil, chunks, err := s.readCollectionHeader(buf, v.Type())
+	if err != nil {
+		return err
+	}
+	if isNil {
+		if policy&MergeUnionMaps == 0 {
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(v.Type(), numEntries))
+	} else if policy&MergeUnionMaps == 0 {
+		iter := v.MapRange()
+		for iter.Next() {
+			v.SetMapIndex(iter.Key(), reflect.Value{})
+		}
+		if v.Len() > 0 {
+			// keys that are not equal to themselves, such as NaN, cannot be deleted, so start over with a new map
+			v.Set(reflect.MakeMapWithSize(v.Type(), numEntries))
+		}
+	}
+	if s.parallelChunks(chunks) {
+		return s.readChunkedMapEntries(v.Type(), buf, v, numEntries, chunks)
+	}
+	// the entries are decoded into new keys and values, with a traversal of their own
+	return s.readMapEntries(buf, v, numEntries)
+}
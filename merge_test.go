@@ -0,0 +1,182 @@
+package bytepack
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type mergedState struct {
+	Version uint64
+	Log     []uint64
+	Names   []string
+	Entries map[string]int32
+	Owner   *person
+	Members []person
+	Window  [4]int16
+	Last    *person
+}
+
+func TestPacker_UnpackIntoMatchesUnpack(t *testing.T) {
+	Register(&person{})
+	s := NewPacker()
+	m := newProjectedMsg()
+	packed, err := s.Pack(&m)
+	assert.NoError(t, err)
+
+	var expected projectedMsg
+	err = s.Unpack(packed, &expected)
+	assert.NoError(t, err)
+
+	// decode into a value holding leftovers of an earlier message
+	dirty := newProjectedMsg()
+	dirty.Payload = make([]byte, 10, 100000)
+	dirty.Cmds = append(dirty.Cmds, projectedCmd{Key: "c", Value: []byte("3")}, projectedCmd{Key: "d"})
+	dirty.Meta["stale"] = []string{"stale"}
+	dirty.Backup = &person{Name: "Stale"}
+	err = s.UnpackInto(packed, &dirty, MergeOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, dirty)
+	assert.Same(t, dirty.Leader, dirty.Backup)
+}
+
+func TestPacker_UnpackIntoReusesMemory(t *testing.T) {
+	s := NewPacker()
+	packed, err := s.Pack(mergedState{
+		Version: 2,
+		Log:     []uint64{1, 2, 3},
+		Names:   []string{"a", "b"},
+		Entries: map[string]int32{"new": 1},
+		Owner:   &person{Name: "Owner"},
+		Members: []person{{Name: "First"}},
+	})
+	assert.NoError(t, err)
+
+	owner := &person{Name: "Old Owner", Age: 50}
+	st := mergedState{
+		Log:     make([]uint64, 0, 16),
+		Names:   make([]string, 5, 8),
+		Entries: map[string]int32{"old": 1},
+		Owner:   owner,
+		Members: []person{{Name: "Old"}, {Name: "Older"}},
+		Last:    &person{Name: "Last"},
+	}
+	logArray := reflect.ValueOf(st.Log).Pointer()
+	namesArray := reflect.ValueOf(st.Names).Pointer()
+	entriesMap := reflect.ValueOf(st.Entries).Pointer()
+
+	err = s.UnpackInto(packed, &st, MergeOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), st.Version)
+	assert.Equal(t, []uint64{1, 2, 3}, st.Log)
+	assert.Equal(t, []string{"a", "b"}, st.Names)
+	assert.Equal(t, map[string]int32{"new": 1}, st.Entries)
+	assert.Equal(t, []person{{Name: "First"}}, st.Members)
+	assert.Nil(t, st.Last)
+	assert.Same(t, owner, st.Owner)
+	assert.Equal(t, person{Name: "Owner"}, *owner)
+	assert.Equal(t, logArray, reflect.ValueOf(st.Log).Pointer())
+	assert.Equal(t, namesArray, reflect.ValueOf(st.Names).Pointer())
+	assert.Equal(t, entriesMap, reflect.ValueOf(st.Entries).Pointer())
+}
+
+func TestPacker_UnpackIntoMerge(t *testing.T) {
+	s := NewPacker()
+	packed, err := s.Pack(mergedState{
+		Version: 2,
+		Log:     []uint64{3, 4},
+		Names:   []string{"c"},
+		Entries: map[string]int32{"b": 20, "c": 30},
+	})
+	assert.NoError(t, err)
+
+	st := mergedState{
+		Version: 1,
+		Log:     []uint64{1, 2},
+		Names:   []string{"a", "b"},
+		Entries: map[string]int32{"a": 1, "b": 2},
+		Members: []person{{Name: "Member"}},
+	}
+	err = s.UnpackInto(packed, &st, MergeAppendSlices|MergeUnionMaps)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), st.Version)
+	assert.Equal(t, []uint64{1, 2, 3, 4}, st.Log)
+	assert.Equal(t, []string{"a", "b", "c"}, st.Names)
+	assert.Equal(t, map[string]int32{"a": 1, "b": 20, "c": 30}, st.Entries)
+	// nil slices in the message leave the existing ones as they are
+	assert.Equal(t, []person{{Name: "Member"}}, st.Members)
+
+	st2 := mergedState{Log: []uint64{1}, Entries: map[string]int32{"a": 1}}
+	err = s.UnpackInto(packed, &st2, MergeAppendSlices)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 3, 4}, st2.Log)
+	assert.Equal(t, map[string]int32{"b": 20, "c": 30}, st2.Entries)
+}
+
+func TestPacker_UnpackIntoClearsNaNKeys(t *testing.T) {
+	type scores struct {
+		M map[float64]int
+	}
+	s := NewPacker()
+	packed, err := s.Pack(scores{M: map[float64]int{1: 10}})
+	assert.NoError(t, err)
+
+	st := scores{M: map[float64]int{math.NaN(): 1, math.NaN(): 2, 3: 3}}
+	assert.NoError(t, s.UnpackInto(packed, &st, MergeOverwrite))
+	assert.Equal(t, map[float64]int{1: 10}, st.M)
+
+	// the union keeps them
+	st = scores{M: map[float64]int{math.NaN(): 1, 3: 3}}
+	assert.NoError(t, s.UnpackInto(packed, &st, MergeUnionMaps))
+	assert.Len(t, st.M, 3)
+}
+
+func TestPacker_UnpackIntoDoesNotShareTargets(t *testing.T) {
+	s := NewPacker()
+	packed, err := s.Pack(mergedState{Owner: &person{Name: "Owner"}, Last: &person{Name: "Last"}})
+	assert.NoError(t, err)
+
+	// both pointers of the target point to the same person, but the message has two
+	shared := &person{Name: "Shared"}
+	st := mergedState{Owner: shared, Last: shared}
+	err = s.UnpackInto(packed, &st, MergeOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, "Owner", st.Owner.Name)
+	assert.Equal(t, "Last", st.Last.Name)
+	assert.NotSame(t, st.Owner, st.Last)
+}
+
+func TestPacker_UnpackIntoAllocatesLess(t *testing.T) {
+	s := NewPacker()
+	msg := mergedState{
+		Version: 2,
+		Log:     make([]uint64, 1000),
+		Entries: map[string]int32{"a": 1, "b": 2},
+		Owner:   &person{Name: "Owner"},
+		Members: make([]person, 10),
+	}
+	packed, err := s.Pack(msg)
+	assert.NoError(t, err)
+
+	var st mergedState
+	unpackAllocs := testing.AllocsPerRun(100, func() {
+		st = mergedState{}
+		_ = s.Unpack(packed, &st)
+	})
+	intoAllocs := testing.AllocsPerRun(100, func() {
+		_ = s.UnpackInto(packed, &st, MergeOverwrite)
+	})
+	assert.Less(t, intoAllocs, unpackAllocs)
+}
+
+func TestBytePack_UnpackInto(t *testing.T) {
+	bp := NewBytePack(1, WithCRC32C())
+	packed, err := bp.Pack(mergedState{Version: 2, Log: []uint64{1}})
+	assert.NoError(t, err)
+
+	st := mergedState{Log: make([]uint64, 0, 4)}
+	err = bp.UnpackInto(packed, &st, MergeOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1}, st.Log)
+}
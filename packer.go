@@ -10,6 +10,7 @@ import (
 	"math"
 	"reflect"
 	"strconv"
+	"time"
 )
 
 var intSize = strconv.IntSize / 8 // intSize in bytes
@@ -30,25 +31,83 @@ type Packable interface {
 }
 
 type Packer struct {
-	w *bytes.Buffer
+	w *encBuffer
+
+	unexportedFields UnexportedFieldPolicy
+	canonical        bool
+	// signing is set while SignedPack packs, which seals encrypted fields inside the canonical encoding
+	signing         bool
+	fieldKeys       KeyProvider
+	missingFieldKey MissingKeyPolicy
+	maxDepth        int
+
+	// sink receives the encoding in chunks while packing with packTo
+	sink    io.Writer
+	sinkBuf *encBuffer
 
 	rootPtrEncoded bool
-	ptrIdCounter   uint16
-	ptrstoid       map[uintptr]uint16
-	idstoptr       map[uint16]*decodingPtr
+	ptrIdCounter   uint32
+	ptrstoid       map[uintptr]uint32
+	idstoptr       map[uint32]*decodingPtr
+	// claimedPtrs holds the existing values UnpackInto decodes into
+	claimedPtrs map[uintptr]bool
+	scratch     []byte
+	// word holds the bytes of the fixed-size value being decoded
+	word [16]byte
+	// sizeEstimates holds a running average of the packed size of each type
+	sizeEstimates map[reflect.Type]int
+	// frames are the nesting levels being traversed, and depth is their number along with the levels of
+	// chunked collections, which have no frame
+	frames []frame
+	depth  int
+	// nested is the number of traversals nested in one another, of which the ones from stackBase on run on the
+	// current goroutine stack
+	nested    int
+	stackBase int
+	// spareEntries hold the states of finished map traversals, for reuse by the next ones
+	spareEntries []*mapEntries
+	// chunking is set for the packers of a BytePack that splits large slices and maps into parallel chunks
+	chunking *chunking
 }
 
 type decodingPtr struct {
 	isDecoded bool
-	waiting   *reflect.Value
 	ptr       reflect.Value
+	// skipped holds the rest of the message, starting at the pointed-to value, when the value was skipped
+	skipped []byte
+}
+
+// UnexportedFieldPolicy
+/*
+   UnexportedFieldPolicy controls what the reflection-based encoder and decoder do with unexported struct fields.
+*/
+type UnexportedFieldPolicy uint8
+
+const (
+	// SkipUnexported leaves unexported fields out of the encoding and untouched when decoding
+	SkipUnexported UnexportedFieldPolicy = iota
+	// IncludeUnexported encodes and decodes unexported fields through unsafe access
+	IncludeUnexported
+)
+
+// PackerOption configures a Packer at construction time
+type PackerOption func(s *Packer)
+
+func WithUnexportedFields(policy UnexportedFieldPolicy) PackerOption {
+	return func(s *Packer) {
+		s.unexportedFields = policy
+	}
 }
 
-func NewPacker() *Packer {
-	return &Packer{
-		w:            new(bytes.Buffer),
+func NewPacker(opts ...PackerOption) *Packer {
+	s := &Packer{
+		w:            new(encBuffer),
 		ptrIdCounter: 0,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 /*~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -56,45 +115,65 @@ func NewPacker() *Packer {
  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
 
 func (s *Packer) Pack(obj interface{}) ([]byte, error) {
-	s.ptrIdCounter = 1
-	s.rootPtrEncoded = false
-	s.ptrstoid = make(map[uintptr]uint16, 0)
+	s.resetEncoder()
+	t := reflect.TypeOf(obj)
+	s.w.Grow(s.estimatedSize(t))
 	err := s.encode(obj)
 	if err != nil {
+		s.w.Reset()
 		return nil, err
 	}
+	s.updateEstimate(t, s.w.Len())
 	retBytes := make([]byte, s.w.Len())
 	copy(retBytes, s.w.Bytes()) // make a copy of a slice, so we can reuse the buffer right away without overwriting
 	s.w.Reset()
+	s.shrinkBuffer(t)
 	return retBytes, nil
 }
 
+// resetEncoder prepares the packer for encoding a new value
+func (s *Packer) resetEncoder() {
+	s.ptrIdCounter = 1
+	s.rootPtrEncoded = false
+	if s.ptrstoid == nil {
+		s.ptrstoid = make(map[uintptr]uint32)
+	}
+	for ptr := range s.ptrstoid {
+		delete(s.ptrstoid, ptr)
+	}
+}
+
 func (s *Packer) encode(obj interface{}) error {
 	switch obj.(type) {
 	case Packable:
 		return obj.(Packable).Pack(s)
+	case nil:
+		return errors.New("cannot encode nil")
 	}
 
 	t := reflect.TypeOf(obj)
 
 	switch t.Kind() {
 	case reflect.Struct:
+		v := reflect.ValueOf(obj)
+		if s.canonical || reflect.PtrTo(t).Implements(packableType) {
+			// a struct has the same canonical form as a pointer to it
+			p := reflect.New(t)
+			p.Elem().Set(v)
+			return s.encode(p.Interface())
+		}
 		if s.ptrIdCounter == 1 {
 			s.ptrIdCounter = 2
 		}
-		v := reflect.ValueOf(obj)
-		p := reflect.New(t)
-		piface := p.Interface()
-		switch piface.(type) {
-		case Packable:
-			p.Elem().Set(v)
-			return piface.(Packable).Pack(s)
+		err := s.checkType(t)
+		if err != nil {
+			return err
 		}
-		err := s.PackUint8(0)
+		err = s.PackUint8(0)
 		if err != nil {
 			return err
 		}
-		err = s.encodeStruct(v)
+		err = s.encodeValue(v)
 		if err != nil {
 			return err
 		}
@@ -104,11 +183,15 @@ func (s *Packer) encode(obj interface{}) error {
 			return errors.New("cannot encode nil struct")
 		}
 		if v.Elem().Kind() == reflect.Struct {
-			err := s.PackUint8(1)
+			err := s.checkType(t)
 			if err != nil {
 				return err
 			}
-			err = s.encodePointer(v)
+			err = s.PackUint8(1)
+			if err != nil {
+				return err
+			}
+			err = s.encodeValue(v)
 			if err != nil {
 				return err
 			}
@@ -117,39 +200,18 @@ func (s *Packer) encode(obj interface{}) error {
 		} else {
 			return errors.New(fmt.Sprintf("cannot encode poiners to non-struct. Got: %v", v.Elem().Kind()))
 		}
-	case reflect.Slice:
-		fallthrough
-	case reflect.Uint64:
-		fallthrough
-	case reflect.Uint32:
-		fallthrough
-	case reflect.Uint16:
-		fallthrough
-	case reflect.Uint8:
-		fallthrough
-	case reflect.Int:
-		fallthrough
-	case reflect.Int64:
-		fallthrough
-	case reflect.Int32:
-		fallthrough
-	case reflect.Int16:
-		fallthrough
-	case reflect.Int8:
-		fallthrough
-	case reflect.String:
-		fallthrough
-	case reflect.Bool:
-		fallthrough
-	case reflect.Float64:
-		fallthrough
-	case reflect.Float32:
-		err := s.encodeValue(reflect.ValueOf(obj))
+	case reflect.Chan:
+		// channels are only skipped as struct fields, on their own they would pack to nothing
+		return unsupportedKindError(t)
+	default:
+		err := s.checkType(t)
+		if err != nil {
+			return err
+		}
+		err = s.encodeValue(reflect.ValueOf(obj))
 		if err != nil {
 			return err
 		}
-	default:
-		return errors.New(fmt.Sprintf("cannot encode %v", t.Kind()))
 	}
 
 	return nil
@@ -159,9 +221,59 @@ func (s *Packer) encode(obj interface{}) error {
   Reflection-based default encoding
  -----------------------------------*/
 
-func (s *Packer) encodeStruct(v reflect.Value) error {
-	for i := 0; i < v.NumField(); i++ {
-		err := s.encodeValue(v.Field(i))
+// encodeValue encodes any value. Structs, collections, pointers and interfaces are traversed with frames,
+// each of them a nesting level.
+func (s *Packer) encodeValue(val reflect.Value) error {
+	base := len(s.frames)
+	err := s.encodeItem(val)
+	if err != nil || len(s.frames) == base {
+		return err
+	}
+	return s.encodeFrames(base)
+}
+
+// encodeFrames encodes the values held by the frames above base
+func (s *Packer) encodeFrames(base int) error {
+	if !s.beginWalk() {
+		return s.onFreshStack(func() error {
+			return s.encodeFrames(base)
+		})
+	}
+	defer s.endWalk(base)
+	for len(s.frames) > base {
+		f := s.top()
+		var err error
+		switch f.kind {
+		case structFrame:
+			if f.i == len(f.fields) {
+				s.pop()
+				continue
+			}
+			fi := f.fields[f.i]
+			f.i++
+			err = s.encodeField(s.field(f.v, fi), fi)
+		case elemsFrame:
+			if f.i == f.n {
+				s.pop()
+				continue
+			}
+			elem := f.v.Index(f.i)
+			f.i++
+			if f.fields == nil {
+				err = s.encodeItem(elem)
+			} else if err = s.flushSink(); err == nil {
+				err = s.pushStruct(elem, f.fields)
+			}
+		case mapFrame:
+			err = s.encodeEntry(f)
+		case valueFrame:
+			if f.i == 1 {
+				s.pop()
+				continue
+			}
+			f.i = 1
+			err = s.encodeItem(f.elem)
+		}
 		if err != nil {
 			return err
 		}
@@ -169,10 +281,26 @@ func (s *Packer) encodeStruct(v reflect.Value) error {
 	return nil
 }
 
-func (s *Packer) encodeValue(val reflect.Value) error {
+// encodeField encodes a single struct field
+func (s *Packer) encodeField(f reflect.Value, fi fieldInfo) error {
+	if fi.encrypt {
+		return s.encodeEncryptedField(f, fi)
+	}
+	if fi.lazy {
+		return s.encodeLazy(f)
+	}
+	return s.encodeItem(f)
+}
+
+// encodeItem encodes val, or if val holds other values, encodes its header and pushes a frame for them
+func (s *Packer) encodeItem(val reflect.Value) error {
+	err := s.flushSink()
+	if err != nil {
+		return err
+	}
 	switch val.Kind() {
 	case reflect.Struct:
-		err := s.encodeStruct(val)
+		err := s.pushStruct(val, s.structFields(val.Type()))
 		if err != nil {
 			return err
 		}
@@ -241,6 +369,21 @@ func (s *Packer) encodeValue(val reflect.Value) error {
 		if err != nil {
 			return err
 		}
+	case reflect.Complex64:
+		err := s.PackComplex64(complex64(val.Complex()))
+		if err != nil {
+			return err
+		}
+	case reflect.Complex128:
+		err := s.PackComplex128(val.Complex())
+		if err != nil {
+			return err
+		}
+	case reflect.Uintptr:
+		err := s.PackUintptr(uintptr(val.Uint()))
+		if err != nil {
+			return err
+		}
 	case reflect.Bool:
 		err := s.PackBool(val.Bool())
 		if err != nil {
@@ -271,188 +414,184 @@ func (s *Packer) encodeValue(val reflect.Value) error {
 		if err != nil {
 			return err
 		}
-	}
-	return nil
-}
-
-func (s *Packer) encodeValueWithType(val reflect.Value) error {
-	switch val.Kind() {
-	case reflect.Ptr:
-		dataType := val.Elem().Type()
-		err := s.PackBool(true) // true for pointer type
-		if err != nil {
-			return err
-		}
-		err = s.PackString(dataType.PkgPath() + dataType.Name())
-		if err != nil {
-			return err
-		}
-		err = s.encodeValue(val.Elem())
-		if err != nil {
-			return err
-		}
-	case reflect.Struct:
-		dataType := val.Type()
-		//log.Debugf("dt := %v", dataType)
-		err := s.PackBool(false) // false for non-pointers
-		if err != nil {
-			return err
-		}
-		err = s.PackString(dataType.PkgPath() + dataType.Name())
-		if err != nil {
-			return err
-		}
-		err = s.encodeStruct(val)
-		//err = s.encode(val.Interface())
-		if err != nil {
-			return err
-		}
+	case reflect.Chan:
+		// channels are not encoded and are left nil when decoding
+	default:
+		return unsupportedKindError(val.Type())
 	}
 	return nil
 }
 
 func (s *Packer) encodePointer(ptr reflect.Value) error {
 	needToWriteValue, _, err := s.encodePointerHeader(ptr)
-	if err != nil {
+	if err != nil || !needToWriteValue {
 		return err
 	}
-	if needToWriteValue {
-		log.Debugf("pointer: %v", ptr.Pointer())
-		err = s.encodeValue(ptr.Elem())
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return s.push(frame{kind: valueFrame, elem: ptr.Elem()})
 }
 
 func (s *Packer) encodePointerHeader(ptr reflect.Value) (bool, bool, error) {
-	header := uint16(0)
 	if ptr.IsNil() {
-		header = 1
-		header = header << 15
-		err := s.PackUint16(header)
+		err := s.PackUint16(nilPointerHeader)
 		return false, false, err
 	}
 
 	needToWriteValue := true
 	rootPtr := false
-	header = header << 15
-	if ptrId, exists := s.ptrstoid[ptr.Pointer()]; exists {
-		header = header | ptrId
+	ptrId, exists := s.ptrstoid[ptr.Pointer()]
+	if exists {
 		needToWriteValue = false
 	} else {
-		s.ptrstoid[ptr.Pointer()] = s.ptrIdCounter
-		header = header | s.ptrIdCounter
-		s.ptrIdCounter++
+		var err error
+		ptrId, err = s.nextPointerID()
+		if err != nil {
+			return false, false, err
+		}
+		s.ptrstoid[ptr.Pointer()] = ptrId
 	}
-	err := s.PackUint16(header)
+	err := s.packPointerID(ptrId)
 	return needToWriteValue, rootPtr, err
 }
 
 func (s *Packer) encodeInterface(iface reflect.Value) error {
 	if iface.IsNil() {
-		err := s.PackBool(false)
-		if err != nil {
-			return err
-		}
-	} else {
-		err := s.PackBool(true)
-		if err != nil {
-			return err
-		}
-		err = s.encodeValueWithType(iface.Elem())
-		if err != nil {
-			return err
+		return s.PackBool(false)
+	}
+	elem := iface.Elem()
+	err := s.checkInterfaceValue(elem)
+	if err != nil {
+		return err
+	}
+	err = s.PackBool(true)
+	if err != nil {
+		return err
+	}
+	// pointers are encoded as the struct they point to, along with a flag and the type name
+	dataType := elem.Type()
+	isPointer := dataType.Kind() == reflect.Ptr
+	if isPointer {
+		dataType = dataType.Elem()
+		elem = elem.Elem()
+	}
+	err = s.PackBool(isPointer)
+	if err != nil {
+		return err
+	}
+	err = s.PackString(dataType.PkgPath() + dataType.Name())
+	if err != nil {
+		return err
+	}
+	return s.push(frame{kind: valueFrame, elem: elem})
+}
+
+// checkInterfaceValue makes sure the dynamic value of an interface can be encoded with its type name
+func (s *Packer) checkInterfaceValue(val reflect.Value) error {
+	dataType := val.Type()
+	if dataType.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return errors.New(fmt.Sprintf("cannot encode nil %v held in interface", dataType))
 		}
-		//log.Debugf("iface elem kind: %v", iface.Elem().Kind())
+		dataType = dataType.Elem()
 	}
-	return nil
+	if dataType.Kind() != reflect.Struct {
+		return errors.New(fmt.Sprintf("cannot encode %v held in interface, only structs and pointers to structs are supported", val.Type()))
+	}
+	return s.checkType(dataType)
 }
 
 func (s *Packer) encodeMap(m reflect.Value) error {
 	if m.IsNil() {
 		return s.PackBool(true)
 	}
+	if numChunks := s.chunkCount(m); numChunks > 0 {
+		return s.encodeChunked(m, numChunks)
+	}
 	err := s.PackBool(false)
+	if err != nil {
+		return err
+	}
 	// write down the number of kv-pairs
 	mapLen := m.Len()
 	err = s.PackInt32(int32(mapLen))
 	if err != nil {
 		return err
 	}
-
-	for _, key := range m.MapKeys() {
-		//write key
-		err = s.encodeValue(key)
+	entries := s.newMapEntries()
+	if s.canonical {
+		entries.sorted, err = s.sortMapEntries(m)
 		if err != nil {
 			return err
 		}
-		//write value
-		val := m.MapIndex(key)
-		err = s.encodeValue(val)
+	} else {
+		// reuse the same key and value for all entries, instead of copying each of them out of the map
+		entries.iter.Reset(m)
+		entries.key = reflect.New(m.Type().Key()).Elem()
+		entries.value = reflect.New(m.Type().Elem()).Elem()
+	}
+	return s.push(frame{kind: mapFrame, v: m, n: mapLen, entries: entries})
+}
+
+// encodeEntry encodes the key or the value of the next entry of map frame f
+func (s *Packer) encodeEntry(f *frame) error {
+	entries := f.entries
+	if entries.sorted != nil {
+		if f.i == f.n {
+			s.pop()
+			return nil
+		}
+		k := entries.sorted.order[f.i]
+		f.i++
+		_, err := s.w.Write(entries.sorted.keyBytes(k))
 		if err != nil {
 			return err
 		}
+		return s.encodeItem(entries.sorted.values[k])
 	}
-	return nil
+	if entries.step == valueNext {
+		entries.step = keyNext
+		entries.value.SetIterValue(&entries.iter)
+		return s.encodeItem(entries.value)
+	}
+	if !entries.iter.Next() {
+		s.pop()
+		return nil
+	}
+	entries.step = valueNext
+	entries.key.SetIterKey(&entries.iter)
+	return s.encodeItem(entries.key)
 }
 
 func (s *Packer) encodeArray(arrayValue reflect.Value) error {
 	// when dealing with slices, first write the number of elements
 	arrayLen := arrayValue.Len()
-	arrayKind := reflect.TypeOf(arrayValue.Interface()).Elem().Kind()
+	arrayKind := arrayValue.Type().Elem().Kind()
 	switch arrayKind {
-	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Bool, reflect.Float32, reflect.Float64:
-		err := binary.Write(s.w, binary.BigEndian, arrayValue.Interface())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		err := s.reach()
 		if err != nil {
 			return err
 		}
-	case reflect.Uint8:
-		for i := 0; i < arrayLen; i++ {
-			err := s.w.WriteByte(byte(arrayValue.Index(i).Uint()))
-			if err != nil {
-				return err
-			}
-		}
-		/*_, err := s.w.Write(arrayValue.
-		  if err != nil {
-		      return err
-		  }*/
-	case reflect.Int:
-		err := s.writeIntSliceOrArray(arrayValue)
+		err = s.writeFixed(arrayValue)
 		if err != nil {
 			return err
 		}
 	case reflect.String:
-		for i := 0; i < arrayLen; i++ {
-			err := s.PackString(arrayValue.Index(i).String())
-			if err != nil {
-				return err
-			}
-		}
-	case reflect.Struct:
-		for i := 0; i < arrayLen; i++ {
-			//err := s.encodeStruct(arrayValue.Index(i))
-			err := s.encode(arrayValue.Index(i).Interface())
-			if err != nil {
-				return err
-			}
+		err := s.reach()
+		if err != nil {
+			return err
 		}
-	case reflect.Map:
 		for i := 0; i < arrayLen; i++ {
-			err := s.encodeMap(arrayValue.Index(i))
+			err := s.PackString(arrayValue.Index(i).String())
 			if err != nil {
 				return err
 			}
-		}
-	case reflect.Ptr:
-		for i := 0; i < arrayLen; i++ {
-			err := s.encodePointer(arrayValue.Index(i))
+			err = s.flushSink()
 			if err != nil {
 				return err
 			}
 		}
+	default:
+		return s.push(frame{kind: elemsFrame, v: arrayValue, n: arrayLen, fields: s.elemFields(arrayValue.Type().Elem())})
 	}
 	return nil
 }
@@ -461,6 +600,9 @@ func (s *Packer) encodeSlice(sliceValue reflect.Value) error {
 	if sliceValue.IsNil() {
 		return s.PackBool(true)
 	}
+	if numChunks := s.chunkCount(sliceValue); numChunks > 0 {
+		return s.encodeChunked(sliceValue, numChunks)
+	}
 	err := s.PackBool(false)
 	if err != nil {
 		return err
@@ -472,57 +614,34 @@ func (s *Packer) encodeSlice(sliceValue reflect.Value) error {
 		return err
 	}
 	//valueField.Slice()
-	sliceKind := reflect.TypeOf(sliceValue.Interface()).Elem().Kind()
+	sliceKind := sliceValue.Type().Elem().Kind()
 	switch sliceKind {
-	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Bool, reflect.Float32, reflect.Float64:
-		err = binary.Write(s.w, binary.BigEndian, sliceValue.Interface())
-		if err != nil {
-			return err
-		}
-	case reflect.Uint8:
-		_, err = s.w.Write(sliceValue.Bytes())
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		err = s.reach()
 		if err != nil {
 			return err
 		}
-	case reflect.Int64:
-		err = s.writeInt64SliceOrArray(sliceValue)
+		err = s.writeFixed(sliceValue)
 		if err != nil {
 			return err
 		}
-	case reflect.Int:
-		err = s.writeIntSliceOrArray(sliceValue)
+	case reflect.String:
+		err = s.reach()
 		if err != nil {
 			return err
 		}
-	case reflect.String:
 		for i := 0; i < sliceLen; i++ {
 			err = s.PackString(sliceValue.Index(i).String())
 			if err != nil {
 				return err
 			}
-		}
-	case reflect.Struct:
-		for i := 0; i < sliceLen; i++ {
-			err = s.encodeStruct(sliceValue.Index(i))
-			//err := s.encode(sliceValue.Index(i).Interface())
-			if err != nil {
-				return err
-			}
-		}
-	case reflect.Map:
-		for i := 0; i < sliceLen; i++ {
-			err = s.encodeMap(sliceValue.Index(i))
-			if err != nil {
-				return err
-			}
-		}
-	case reflect.Ptr:
-		for i := 0; i < sliceLen; i++ {
-			err = s.encodePointer(sliceValue.Index(i))
+			err = s.flushSink()
 			if err != nil {
 				return err
 			}
 		}
+	default:
+		return s.push(frame{kind: elemsFrame, v: sliceValue, n: sliceLen, fields: s.elemFields(sliceValue.Type().Elem())})
 	}
 	return nil
 }
@@ -540,213 +659,157 @@ func (s *Packer) PackString(str string) error {
 	return err
 }
 
-func (s *Packer) writeInt64SliceOrArray(arrayValue reflect.Value) error {
-	arrayLen := arrayValue.Len()
-	bs := make([]byte, 8*arrayLen)
-	for i := 0; i < arrayLen; i++ {
-		uival := uint64(arrayValue.Index(i).Int())
-		bs[8*i] = byte(uival >> 56)
-		bs[8*i+1] = byte(uival >> 48)
-		bs[8*i+2] = byte(uival >> 40)
-		bs[8*i+3] = byte(uival >> 32)
-		bs[8*i+4] = byte(uival >> 24)
-		bs[8*i+5] = byte(uival >> 16)
-		bs[8*i+6] = byte(uival >> 8)
-		bs[8*i+7] = byte(uival)
-	}
-	_, err := s.w.Write(bs)
-	return err
+func (s *Packer) PackInt32(ival int32) error {
+	binary.BigEndian.PutUint32(s.w.extend(4), uint32(ival))
+	return nil
 }
 
-func (s *Packer) writeIntSliceOrArray(arrayValue reflect.Value) error {
-	arrayLen := arrayValue.Len()
-	if intSize == 8 {
-		bs := make([]byte, 8*arrayLen)
-		for i := 0; i < arrayLen; i++ {
-			uival := uint64(arrayValue.Index(i).Int())
-			bs[8*i] = byte(uival >> 56)
-			bs[8*i+1] = byte(uival >> 48)
-			bs[8*i+2] = byte(uival >> 40)
-			bs[8*i+3] = byte(uival >> 32)
-			bs[8*i+4] = byte(uival >> 24)
-			bs[8*i+5] = byte(uival >> 16)
-			bs[8*i+6] = byte(uival >> 8)
-			bs[8*i+7] = byte(uival)
-		}
-		_, err := s.w.Write(bs)
-		return err
-	} else if intSize == 4 {
-		bs := make([]byte, 4*arrayLen)
-		for i := 0; i < arrayLen; i++ {
-			uival := uint32(arrayValue.Index(i).Int())
-			bs[8*i] = byte(uival >> 24)
-			bs[8*i+1] = byte(uival >> 16)
-			bs[8*i+2] = byte(uival >> 8)
-			bs[8*i+3] = byte(uival)
-		}
-		_, err := s.w.Write(bs)
-		return err
+func (s *Packer) PackInt64(ival int64) error {
+	binary.BigEndian.PutUint64(s.w.extend(8), uint64(ival))
+	return nil
+}
+
+func (s *Packer) PackInt(ival int) error {
+	if s.intWidth() == 8 {
+		return s.PackInt64(int64(ival))
+	} else if s.intWidth() == 4 {
+		return s.PackInt32(int32(ival))
 	}
 	return errors.New("unknown int size")
 }
 
-func (s *Packer) PackInt32(ival int32) error {
-	uival := uint32(ival)
-	err := s.w.WriteByte(byte(uival >> 24))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 16))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 8))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival))
-	return err
+func (s *Packer) PackInt8(ival int8) error {
+	return s.w.WriteByte(uint8(ival))
 }
 
-func (s *Packer) PackInt64(ival int64) error {
-	uival := uint64(ival)
-	err := s.w.WriteByte(byte(uival >> 56))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 48))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 40))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 32))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 24))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 16))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 8))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival))
-	return err
+func (s *Packer) PackInt16(ival int16) error {
+	binary.BigEndian.PutUint16(s.w.extend(2), uint16(ival))
+	return nil
 }
 
-func (s *Packer) PackInt(ival int) error {
-	if intSize == 8 {
-		return s.PackInt64(int64(ival))
-	} else if intSize == 4 {
-		return s.PackInt32(int32(ival))
+func (s *Packer) PackUint(ival uint) error {
+	if s.intWidth() == 8 {
+		return s.PackUint64(uint64(ival))
+	} else if s.intWidth() == 4 {
+		return s.PackUint32(uint32(ival))
 	}
 	return errors.New("unknown int size")
 }
 
-func (s *Packer) PackInt8(ival int8) error {
-	return s.w.WriteByte(uint8(ival))
+func (s *Packer) PackUint8(uival uint8) error {
+	return s.w.WriteByte(uival)
 }
 
-func (s *Packer) PackInt16(ival int16) error {
-	uival := uint16(ival)
-	err := s.w.WriteByte(byte(uival >> 8))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival))
-	return err
+func (s *Packer) PackUint16(uival uint16) error {
+	binary.BigEndian.PutUint16(s.w.extend(2), uival)
+	return nil
 }
 
-func (s *Packer) PackUint(ival uint) error {
-	return binary.Write(s.w, binary.BigEndian, ival)
+func (s *Packer) PackUint32(uival uint32) error {
+	binary.BigEndian.PutUint32(s.w.extend(4), uival)
+	return nil
 }
 
-func (s *Packer) PackUint8(uival uint8) error {
-	return s.w.WriteByte(uival)
+func (s *Packer) PackUint64(uival uint64) error {
+	binary.BigEndian.PutUint64(s.w.extend(8), uival)
+	return nil
 }
 
-func (s *Packer) PackUint16(uival uint16) error {
-	err := s.w.WriteByte(byte(uival >> 8))
-	if err != nil {
-		return err
+func (s *Packer) PackFloat64(fval float64) error {
+	if s.canonical {
+		return s.PackUint64(canonicalFloat64bits(fval))
 	}
-	err = s.w.WriteByte(byte(uival))
-	return err
+	return s.PackUint64(math.Float64bits(fval))
 }
 
-func (s *Packer) PackUint32(uival uint32) error {
-	err := s.w.WriteByte(byte(uival >> 24))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 16))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 8))
-	if err != nil {
-		return err
+func (s *Packer) PackFloat32(fval float32) error {
+	if s.canonical {
+		return s.PackUint32(canonicalFloat32bits(fval))
 	}
-	err = s.w.WriteByte(byte(uival))
-	return err
+	return s.PackUint32(math.Float32bits(fval))
 }
 
-func (s *Packer) PackUint64(uival uint64) error {
-	err := s.w.WriteByte(byte(uival >> 56))
+// PackComplex64 writes the real part followed by the imaginary part
+func (s *Packer) PackComplex64(cval complex64) error {
+	err := s.PackFloat32(real(cval))
 	if err != nil {
 		return err
 	}
-	err = s.w.WriteByte(byte(uival >> 48))
-	if err != nil {
-		return err
-	}
-	err = s.w.WriteByte(byte(uival >> 40))
+	return s.PackFloat32(imag(cval))
+}
+
+// PackComplex128 writes the real part followed by the imaginary part
+func (s *Packer) PackComplex128(cval complex128) error {
+	err := s.PackFloat64(real(cval))
 	if err != nil {
 		return err
 	}
-	err = s.w.WriteByte(byte(uival >> 32))
-	if err != nil {
-		return err
+	return s.PackFloat64(imag(cval))
+}
+
+// PackUintptr always writes 8 bytes, regardless of the pointer size of the platform
+func (s *Packer) PackUintptr(uival uintptr) error {
+	return s.PackUint64(uint64(uival))
+}
+
+func (s *Packer) PackBool(bval bool) error {
+	//return binary.Write(s.w, binary.BigEndian, bval)
+	if bval {
+		return s.w.WriteByte(1)
+	} else {
+		return s.w.WriteByte(0)
 	}
-	err = s.w.WriteByte(byte(uival >> 24))
-	if err != nil {
-		return err
+}
+
+// PackBytes writes b the same way a []byte field is encoded, so a nil slice stays nil
+func (s *Packer) PackBytes(b []byte) error {
+	if b == nil {
+		return s.PackBool(true)
 	}
-	err = s.w.WriteByte(byte(uival >> 16))
+	err := s.PackBool(false)
 	if err != nil {
 		return err
 	}
-	err = s.w.WriteByte(byte(uival >> 8))
+	err = s.PackInt32(int32(len(b)))
 	if err != nil {
 		return err
 	}
-	err = s.w.WriteByte(byte(uival))
+	_, err = s.w.Write(b)
 	return err
 }
 
-func (s *Packer) PackFloat64(fval float64) error {
-	return s.PackUint64(math.Float64bits(fval))
+// PackVarint writes ival in 1 to 10 bytes with the zig-zag varint encoding of encoding/binary, so small magnitudes take less space
+func (s *Packer) PackVarint(ival int64) error {
+	s.w.b = binary.AppendVarint(s.w.b, ival)
+	return nil
 }
 
-func (s *Packer) PackFloat32(fval float32) error {
-	return s.PackUint32(math.Float32bits(fval))
+// PackUvarint writes uival in 1 to 10 bytes with the varint encoding of encoding/binary
+func (s *Packer) PackUvarint(uival uint64) error {
+	s.w.b = binary.AppendUvarint(s.w.b, uival)
+	return nil
 }
 
-func (s *Packer) PackBool(bval bool) error {
-	//return binary.Write(s.w, binary.BigEndian, bval)
-	if bval {
-		return s.w.WriteByte(1)
-	} else {
-		return s.w.WriteByte(0)
+// PackTime writes t as seconds since the Unix epoch, nanoseconds, and the zone offset in minutes (-1 for UTC).
+// Like time.Time.MarshalBinary, it keeps the offset but not the name of the location, and drops the monotonic clock reading.
+func (s *Packer) PackTime(t time.Time) error {
+	offsetMin := int16(-1) // UTC
+	if t.Location() != time.UTC {
+		_, offset := t.Zone()
+		if offset%60 != 0 || offset/60 < -32768 || offset/60 == -1 || offset/60 > 32767 {
+			return errors.New(fmt.Sprintf("time zone offset %ds cannot be packed", offset))
+		}
+		offsetMin = int16(offset / 60)
 	}
+	err := s.PackInt64(t.Unix())
+	if err != nil {
+		return err
+	}
+	err = s.PackUint32(uint32(t.Nanosecond()))
+	if err != nil {
+		return err
+	}
+	return s.PackInt16(offsetMin)
 }
 
 func (s *Packer) PackStruct(obj interface{}) error {
@@ -756,7 +819,7 @@ func (s *Packer) PackStruct(obj interface{}) error {
 func (s *Packer) PackSlice(slice interface{}) error {
 	sliceVal := reflect.ValueOf(slice)
 	if sliceVal.Type().Kind() == reflect.Slice {
-		err := s.encodeSlice(sliceVal)
+		err := s.encodeValue(sliceVal)
 		if err != nil {
 			return err
 		}
@@ -769,7 +832,7 @@ func (s *Packer) PackSlice(slice interface{}) error {
 func (s *Packer) PackMap(m interface{}) error {
 	mapVal := reflect.ValueOf(m)
 	if mapVal.Type().Kind() == reflect.Map {
-		err := s.encodeMap(mapVal)
+		err := s.encodeValue(mapVal)
 		if err != nil {
 			return err
 		}
@@ -784,12 +847,11 @@ func (s *Packer) PackMap(m interface{}) error {
  ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~*/
 
 func (s *Packer) Unpack(data []byte, obj interface{}) error {
-	buf := bytes.NewBuffer(data)
-	return s.UnpackFromReader(buf, obj)
+	return s.UnpackFromReader(newSliceReader(data), obj)
 }
 
 func (s *Packer) UnpackFromReader(buf BPReader, obj interface{}) error {
-	s.idstoptr = make(map[uint16]*decodingPtr, 0)
+	s.idstoptr = make(map[uint32]*decodingPtr, 0)
 	switch obj.(type) {
 	case Packable:
 		return obj.(Packable).Unpack(s, buf)
@@ -799,6 +861,11 @@ func (s *Packer) UnpackFromReader(buf BPReader, obj interface{}) error {
 			return errors.New("must pass a pointer to an object")
 		}
 
+		err := s.checkType(v.Elem().Type())
+		if err != nil {
+			return err
+		}
+
 		switch v.Elem().Kind() {
 		case reflect.Struct:
 			flag, err := s.UnpackUint8(buf)
@@ -821,242 +888,331 @@ func (s *Packer) UnpackFromReader(buf BPReader, obj interface{}) error {
 				}
 			}
 			return nil
-		case reflect.Slice:
-			fallthrough
-		case reflect.Uint64:
-			fallthrough
-		case reflect.Uint32:
-			fallthrough
-		case reflect.Uint16:
-			fallthrough
-		case reflect.Uint8:
-			fallthrough
-		case reflect.Int:
-			fallthrough
-		case reflect.Int64:
-			fallthrough
-		case reflect.Int32:
-			fallthrough
-		case reflect.Int16:
-			fallthrough
-		case reflect.Int8:
-			fallthrough
-		case reflect.String:
-			fallthrough
-		case reflect.Bool:
-			fallthrough
-		case reflect.Float64:
-			fallthrough
-		case reflect.Float32:
+		case reflect.Interface, reflect.Ptr, reflect.Chan:
+			return fmt.Errorf("cannot unpack this type")
+		default:
 			val, err := s.readBasicValues(v.Elem().Type(), buf)
 			if err != nil {
 				return err
 			}
 			v.Elem().Set(val)
-		default:
-			return fmt.Errorf("cannot unpack this type")
 		}
 	}
 	return nil
 }
 
+// readStruct decodes the fields of struct objVal in place
 func (s *Packer) readStruct(buf BPReader, objVal reflect.Value) error {
-	numFields := objVal.NumField()
-	for i := 0; i < numFields; i++ {
-		f := objVal.Field(i)
-		ft := objVal.Field(i).Type()
-		// we could have used the readBasicValues method here:
-		// ---------------------
-		/*val, err := s.readBasicValues(ft.Type, buf)
-		  if err != nil {
-		      return err
-		  }
-		  f.Set(val)*/
-		// ---------------------
-		//but it seems to have worse perf than rewriting it specific to the struct
-
-		switch ft.Kind() {
-		case reflect.Struct:
-			//initializeStruct(ft.Type, f)
-			st := reflect.New(ft)
-			err := s.readStruct(buf, st.Elem())
-			if err != nil {
-				return err
+	base := len(s.frames)
+	err := s.pushStruct(objVal, s.structFields(objVal.Type()))
+	if err != nil {
+		return err
+	}
+	return s.readFrames(buf, base)
+}
+
+// readStructField decodes a single struct field, or any other settable value, in place
+func (s *Packer) readStructField(buf BPReader, f reflect.Value, fi fieldInfo) error {
+	base := len(s.frames)
+	err := s.readField(buf, f, fi)
+	if err != nil || len(s.frames) == base {
+		return err
+	}
+	return s.readFrames(buf, base)
+}
+
+// readValue decodes a value in place into the settable v. Structs, collections, pointers and interfaces are
+// traversed with frames, each of them a nesting level.
+func (s *Packer) readValue(buf BPReader, v reflect.Value) error {
+	base := len(s.frames)
+	err := s.readItem(buf, v)
+	if err != nil || len(s.frames) == base {
+		return err
+	}
+	return s.readFrames(buf, base)
+}
+
+// readFrames decodes the values held by the frames above base
+func (s *Packer) readFrames(buf BPReader, base int) error {
+	if !s.beginWalk() {
+		return s.onFreshStack(func() error {
+			return s.readFrames(buf, base)
+		})
+	}
+	defer s.endWalk(base)
+	for len(s.frames) > base {
+		f := s.top()
+		var err error
+		switch f.kind {
+		case structFrame:
+			if f.i == len(f.fields) {
+				s.pop()
+				continue
 			}
-			f.Set(st.Elem())
-		case reflect.Ptr:
-			err := s.readPointerForStruct(ft, f, buf)
-			if err != nil {
-				return err
+			fi := f.fields[f.i]
+			f.i++
+			err = s.readField(buf, s.field(f.v, fi), fi)
+		case elemsFrame:
+			if f.i == f.n {
+				s.pop()
+				continue
 			}
-		case reflect.String:
-			str, err := s.UnpackString(buf)
-			if err != nil {
-				return err
+			elem := f.v.Index(f.i)
+			f.i++
+			if f.fields != nil {
+				// elements start out zero, so they need no zeroing like fields do
+				err = s.pushStruct(elem, f.fields)
+			} else {
+				err = s.readItem(buf, elem)
 			}
-			f.SetString(str)
-		case reflect.Int:
-			if intSize == 8 {
-				intVal, err := s.UnpackInt64(buf)
-				if err != nil {
-					return err
-				}
-				f.SetInt(intVal)
-			} else if intSize == 4 {
-				intVal, err := s.UnpackInt32(buf)
-				if err != nil {
-					return err
+		case mapFrame:
+			err = s.readEntry(buf, f)
+		case valueFrame:
+			if f.i == 1 {
+				if f.v.IsValid() {
+					// a struct held in an interface is set once it is decoded
+					f.v.Set(f.elem)
 				}
-				f.SetInt(int64(intVal))
-			}
-		case reflect.Int32:
-			intVal, err := s.UnpackInt32(buf)
-			if err != nil {
-				return err
+				s.pop()
+				continue
 			}
-			f.SetInt(int64(intVal))
-		case reflect.Int64:
+			f.i = 1
+			err = s.readItem(buf, f.elem)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readField decodes a single struct field in place, pushing a frame if the field holds other values
+func (s *Packer) readField(buf BPReader, f reflect.Value, fi fieldInfo) error {
+	if fi.encrypt {
+		return s.readEncryptedField(buf, f, fi)
+	}
+	if fi.lazy {
+		return s.readLazy(buf, f)
+	}
+	return s.readItem(buf, f)
+}
+
+// readItem decodes a value in place into the settable f, or if f holds other values, decodes its header and
+// pushes a frame for them
+func (s *Packer) readItem(buf BPReader, f reflect.Value) error {
+	ft := f.Type()
+	// we could have used the readBasicValues method here:
+	// ---------------------
+	/*val, err := s.readBasicValues(ft.Type, buf)
+	  if err != nil {
+	      return err
+	  }
+	  f.Set(val)*/
+	// ---------------------
+	//but it seems to have worse perf than rewriting it specific to the struct
+
+	switch ft.Kind() {
+	case reflect.Struct:
+		// fields missing from the encoding, such as unexported ones, are zeroed like in a new struct
+		f.Set(reflect.Zero(ft))
+		return s.pushStruct(f, s.structFields(ft))
+	case reflect.Ptr:
+		return s.readPointer(buf, f)
+	case reflect.String:
+		str, err := s.UnpackString(buf)
+		if err != nil {
+			return err
+		}
+		f.SetString(str)
+	case reflect.Int:
+		if s.intWidth() == 8 {
 			intVal, err := s.UnpackInt64(buf)
 			if err != nil {
 				return err
 			}
 			f.SetInt(intVal)
-		case reflect.Float64:
-			floatVal, err := s.UnpackFloat64(buf)
-			if err != nil {
-				return err
-			}
-			f.SetFloat(floatVal)
-		case reflect.Float32:
-			floatVal, err := s.UnpackFloat32(buf)
-			if err != nil {
-				return err
-			}
-			f.SetFloat(float64(floatVal))
-		case reflect.Bool:
-			var boolVal bool
-			err := binary.Read(buf, binary.BigEndian, &boolVal)
-			if err != nil {
-				return err
-			}
-			f.SetBool(boolVal)
-		case reflect.Int8:
-			intVal, err := s.UnpackInt8(buf)
-			if err != nil {
-				return err
-			}
-			f.SetInt(int64(intVal))
-		case reflect.Int16:
-			intVal, err := s.UnpackInt16(buf)
+		} else if s.intWidth() == 4 {
+			intVal, err := s.UnpackInt32(buf)
 			if err != nil {
 				return err
 			}
 			f.SetInt(int64(intVal))
-		case reflect.Uint8:
-			intVal, err := s.UnpackUint8(buf)
-			if err != nil {
-				return err
-			}
-			f.SetUint(uint64(intVal))
-		case reflect.Uint16:
-			intVal, err := s.UnpackUint16(buf)
-			if err != nil {
-				return err
-			}
-			f.SetUint(uint64(intVal))
-		case reflect.Uint32:
-			intVal, err := s.UnpackUint32(buf)
-			if err != nil {
-				return err
-			}
-			f.SetUint(uint64(intVal))
-		case reflect.Uint64:
-			intVal, err := s.UnpackUint64(buf)
-			if err != nil {
-				return err
-			}
-			f.SetUint(intVal)
-		case reflect.Slice:
-			sliceVal, err := s.UnpackSlice(ft, buf)
-			if err != nil {
-				return err
-			}
-			if sliceVal != nil {
-				f.Set(*sliceVal)
-			}
-		case reflect.Array:
-			arrayVal, err := s.UnpackArray(ft, buf)
-			if err != nil {
-				return err
-			}
-			f.Set(*arrayVal)
-		case reflect.Map:
-			decodedMap := reflect.MakeMap(ft)
-			exists, err := s.readMap(ft, buf, decodedMap)
-			if err != nil {
-				return err
-			}
-			if exists {
-				f.Set(decodedMap)
-			}
-		case reflect.Interface:
-			val, err := s.readInterface(buf)
-			if err != nil {
-				log.Errorf("Error reading interface. Partial object: %v", f.Interface())
-				return err
-			}
-			if val != nil {
-				f.Set(*val)
-			}
-		case reflect.Chan:
-			// do nothing with the chan and leave it nil
-		default:
-			return errors.New(fmt.Sprintf("decoding unsupported type %v", ft.Kind()))
 		}
+	case reflect.Int32:
+		intVal, err := s.UnpackInt32(buf)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(intVal))
+	case reflect.Int64:
+		intVal, err := s.UnpackInt64(buf)
+		if err != nil {
+			return err
+		}
+		f.SetInt(intVal)
+	case reflect.Float64:
+		floatVal, err := s.UnpackFloat64(buf)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(floatVal)
+	case reflect.Float32:
+		floatVal, err := s.UnpackFloat32(buf)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(float64(floatVal))
+	case reflect.Complex64:
+		complexVal, err := s.UnpackComplex64(buf)
+		if err != nil {
+			return err
+		}
+		f.SetComplex(complex128(complexVal))
+	case reflect.Complex128:
+		complexVal, err := s.UnpackComplex128(buf)
+		if err != nil {
+			return err
+		}
+		f.SetComplex(complexVal)
+	case reflect.Uintptr:
+		intVal, err := s.UnpackUintptr(buf)
+		if err != nil {
+			return err
+		}
+		f.SetUint(uint64(intVal))
+	case reflect.Bool:
+		boolVal, err := s.UnpackBool(buf)
+		if err != nil {
+			return err
+		}
+		f.SetBool(boolVal)
+	case reflect.Int8:
+		intVal, err := s.UnpackInt8(buf)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(intVal))
+	case reflect.Int16:
+		intVal, err := s.UnpackInt16(buf)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(intVal))
+	case reflect.Uint8:
+		intVal, err := s.UnpackUint8(buf)
+		if err != nil {
+			return err
+		}
+		f.SetUint(uint64(intVal))
+	case reflect.Uint16:
+		intVal, err := s.UnpackUint16(buf)
+		if err != nil {
+			return err
+		}
+		f.SetUint(uint64(intVal))
+	case reflect.Uint32:
+		intVal, err := s.UnpackUint32(buf)
+		if err != nil {
+			return err
+		}
+		f.SetUint(uint64(intVal))
+	case reflect.Uint64:
+		intVal, err := s.UnpackUint64(buf)
+		if err != nil {
+			return err
+		}
+		f.SetUint(intVal)
+	case reflect.Uint:
+		intVal, err := s.UnpackUint(buf)
+		if err != nil {
+			return err
+		}
+		f.SetUint(uint64(intVal))
+	case reflect.Slice:
+		return s.readSlice(buf, f)
+	case reflect.Array:
+		return s.readArray(buf, f)
+	case reflect.Map:
+		return s.readMap(buf, f)
+	case reflect.Interface:
+		err := s.readInterface(buf, f)
+		if err != nil {
+			log.Errorf("Error reading interface. Partial object: %v", f.Interface())
+			return err
+		}
+	case reflect.Chan:
+		// do nothing with the chan and leave it nil
+	default:
+		return unsupportedKindError(ft)
 	}
-
 	return nil
 }
 
-func (s *Packer) readMap(mapType reflect.Type, buf BPReader, readMap reflect.Value) (bool, error) {
-	// read nil flag
-	isNil, err := s.UnpackBool(buf)
-	if isNil {
-		return false, nil
+func (s *Packer) readMap(buf BPReader, f reflect.Value) error {
+	// read nil flag and the number of entries
+	mapType := f.Type()
+	numEntries, isNil, chunks, err := s.readCollectionHeader(buf, mapType)
+	if err != nil || isNil {
+		return err
+	}
+	decodedMap := reflect.MakeMap(mapType)
+	if s.parallelChunks(chunks) {
+		err = s.readChunkedMapEntries(mapType, buf, decodedMap, numEntries, chunks)
+		if err != nil {
+			return err
+		}
+		f.Set(decodedMap)
+		return nil
 	}
+	f.Set(decodedMap)
+	return s.push(frame{kind: mapFrame, v: decodedMap, n: numEntries, entries: s.newMapEntries(), fields: s.elemFields(mapType.Elem())})
+}
 
-	numEntries, err := s.UnpackInt32(buf)
+// readMapEntries decodes numEntries map entries and adds them to readMap
+func (s *Packer) readMapEntries(buf BPReader, readMap reflect.Value, numEntries int) error {
+	base := len(s.frames)
+	err := s.push(frame{kind: mapFrame, v: readMap, n: numEntries, entries: s.newMapEntries(), fields: s.elemFields(readMap.Type().Elem())})
 	if err != nil {
-		return false, err
+		return err
 	}
-	var mapKey reflect.Value
-	var mapValue reflect.Value
-	for i := 0; i < int(numEntries); i++ {
-		// decode key
-		mapKey, err = s.readBasicValues(mapType.Key(), buf)
-		if err != nil {
-			return false, err
+	return s.readFrames(buf, base)
+}
+
+// readEntry decodes the key or the value of the next entry of map frame f, and adds the entry to the map
+// once both are decoded
+func (s *Packer) readEntry(buf BPReader, f *frame) error {
+	entries := f.entries
+	switch entries.step {
+	case entryDone:
+		f.v.SetMapIndex(entries.key, entries.value)
+		f.i++
+		fallthrough
+	case keyNext:
+		if f.i == f.n {
+			s.pop()
+			return nil
 		}
-		//decode value
-		mapValue, err = s.readBasicValues(mapType.Elem(), buf)
-		if err != nil {
-			return false, err
+		entries.key = reflect.New(f.v.Type().Key()).Elem()
+		entries.step = valueNext
+		return s.readItem(buf, entries.key)
+	default:
+		entries.value = reflect.New(f.v.Type().Elem()).Elem()
+		entries.step = entryDone
+		if f.fields != nil {
+			// a new struct is zero already
+			return s.pushStruct(entries.value, f.fields)
 		}
-		// set to map
-		readMap.SetMapIndex(mapKey, mapValue)
+		return s.readItem(buf, entries.value)
 	}
-	return true, nil
 }
 
 func (s *Packer) readRootPointer(obj reflect.Value, buf BPReader) error {
 	// first read ptr header
-	header, err := s.UnpackUint16(buf)
+	ptrId, isNil, err := s.unpackPointerHeader(buf)
 	if err != nil {
 		return err
 	}
-	isNil := header >> 15
-	if isNil == 0 {
-		ptrId := (header << 1) >> 1
+	if !isNil {
 		if ptrId != 1 {
 			return errors.New("invalid root pointer")
 		}
@@ -1064,255 +1220,183 @@ func (s *Packer) readRootPointer(obj reflect.Value, buf BPReader) error {
 			isDecoded: true,
 			ptr:       obj,
 		}
-		val, err := s.readBasicValues(obj.Type().Elem(), buf)
-		if err != nil {
-			return err
-		}
-		obj.Elem().Set(val)
-
-		return err
-
+		return s.readValue(buf, obj.Elem())
 	}
 	return nil
 }
 
-func (s *Packer) readPointer(ptrType reflect.Type, buf BPReader) (reflect.Value, error) {
+func (s *Packer) readPointer(buf BPReader, f reflect.Value) error {
 	// first read ptr header
-	header, err := s.UnpackUint16(buf)
-	if err != nil {
-		return reflect.New(ptrType).Elem(), err
-	}
-	isNil := header >> 15
-	if isNil == 0 {
-		ptrId := (header << 1) >> 1
-		if s.idstoptr[ptrId] != nil {
-			if s.idstoptr[ptrId].isDecoded {
-				return s.idstoptr[ptrId].ptr, nil
-			}
-		} else {
-			s.idstoptr[ptrId] = &decodingPtr{
-				isDecoded: false,
-			}
-			val, err := s.readBasicValues(ptrType.Elem(), buf)
-			if err != nil {
-				return reflect.New(ptrType).Elem(), err
-			}
-			ptr := val.Addr()
-			s.idstoptr[ptrId].ptr = ptr
-
-			return ptr, err
-		}
-	}
-	return reflect.New(ptrType).Elem(), nil
-}
-
-func (s *Packer) readPointerForStruct(ptrType reflect.Type, structFieldVal reflect.Value, buf BPReader) error {
-	// first read ptr header
-	header, err := s.UnpackUint16(buf)
-	if err != nil {
+	ptrId, isNil, err := s.unpackPointerHeader(buf)
+	if err != nil || isNil {
 		return err
 	}
-	isNil := header >> 15
-	if isNil == 0 {
-		ptrId := (header << 1) >> 1
-		if s.idstoptr[ptrId] != nil {
-			if s.idstoptr[ptrId].isDecoded {
-				structFieldVal.Set(s.idstoptr[ptrId].ptr)
-				return nil
-			} else {
-				s.idstoptr[ptrId].waiting = &structFieldVal
-			}
-		} else {
-			s.idstoptr[ptrId] = &decodingPtr{
-				isDecoded: false,
-			}
-			val, err := s.readBasicValues(ptrType.Elem(), buf)
+	if dp := s.idstoptr[ptrId]; dp != nil {
+		ptr := dp.ptr
+		if dp.skipped != nil {
+			ptr, err = s.readSkippedPointer(f.Type(), ptrId)
 			if err != nil {
 				return err
 			}
-			ptr := val.Addr()
-			s.idstoptr[ptrId].ptr = ptr
-			if s.idstoptr[ptrId].waiting != nil {
-				s.idstoptr[ptrId].waiting.Set(ptr)
-			}
-			structFieldVal.Set(ptr)
-			return err
 		}
+		if !ptr.IsValid() {
+			// the value was skipped in a stream, and cannot be read anymore
+			return nil
+		}
+		if ptr.Type() != f.Type() {
+			return errors.New(fmt.Sprintf("pointer %d is a %v, not a %v", ptrId, ptr.Type(), f.Type()))
+		}
+		f.Set(ptr)
+		return nil
 	}
-	return nil
+	// the pointer is known before its value is decoded, so references to it from within the value resolve to it
+	ptr := reflect.New(f.Type().Elem())
+	s.idstoptr[ptrId] = &decodingPtr{
+		isDecoded: true,
+		ptr:       ptr,
+	}
+	f.Set(ptr)
+	return s.push(frame{kind: valueFrame, elem: ptr.Elem()})
 }
 
-func (s *Packer) readInterface(buf BPReader) (*reflect.Value, error) {
+func (s *Packer) readInterface(buf BPReader, f reflect.Value) error {
 	// first read interface nil flag
 	notNil, err := s.UnpackBool(buf)
+	if err != nil || !notNil {
+		return err
+	}
+	// read if pointer
+	isPointer, err := s.UnpackBool(buf)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if notNil {
-		// read if pointer
-		isPointer, err := s.UnpackBool(buf)
-		if err != nil {
-			return nil, err
-		}
-		// read the type
-		typeStr, err := s.UnpackString(buf)
-		if err != nil {
-			return nil, err
-		}
-		if ifaceType, exists := registeredStructs[typeStr]; exists {
-			val, err := s.readBasicValues(ifaceType, buf)
-			if err != nil {
-				return nil, err
-			}
-			if isPointer {
-				val = val.Addr()
-			}
-			return &val, err
-		} else {
-			if len(typeStr) > 255 {
-				return nil, errors.New(fmt.Sprintf("type %s... is not registered", typeStr[0:255]))
-			}
-			return nil, errors.New(fmt.Sprintf("type %s is not registered", typeStr))
-		}
+	// read the type
+	typeStr, err := s.UnpackString(buf)
+	if err != nil {
+		return err
+	}
+	ifaceType, exists := registeredType(typeStr)
+	if !exists {
+		return unregisteredTypeError(typeStr)
+	}
+	err = s.checkType(ifaceType)
+	if err != nil {
+		return err
+	}
+	val := reflect.New(ifaceType)
+	heldType := ifaceType
+	if isPointer {
+		heldType = val.Type()
+	}
+	if !heldType.AssignableTo(f.Type()) {
+		return errors.New(fmt.Sprintf("%v cannot be held in %v", heldType, f.Type()))
+	}
+	if isPointer {
+		f.Set(val)
+		return s.push(frame{kind: valueFrame, elem: val.Elem()})
+	}
+	return s.push(frame{kind: valueFrame, v: f, elem: val.Elem()})
+}
+
+func unregisteredTypeError(typeStr string) error {
+	if len(typeStr) > 255 {
+		return errors.New(fmt.Sprintf("type %s... is not registered", typeStr[0:255]))
 	}
-	return nil, nil
+	return errors.New(fmt.Sprintf("type %s is not registered", typeStr))
 }
 
+// readBasicValues decodes a new value of type valType
 func (s *Packer) readBasicValues(valType reflect.Type, buf BPReader) (reflect.Value, error) {
-	val := reflect.New(valType)
-	k := valType.Kind()
-	switch k {
-	case reflect.Int:
-		if intSize == 8 {
-			intVal, err := s.UnpackInt64(buf)
-			if err != nil {
-				return val, err
-			}
-			val = reflect.ValueOf(intVal)
-		} else if intSize == 4 {
-			intVal, err := s.UnpackInt32(buf)
-			if err != nil {
-				return val, err
-			}
-			val = reflect.ValueOf(intVal)
-		}
-	case reflect.Int8:
-		intVal, err := s.UnpackInt8(buf)
-		if err != nil {
-			return val, err
-		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Int16:
-		intVal, err := s.UnpackInt16(buf)
-		if err != nil {
-			return val, err
-		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Uint:
-		if intSize == 8 {
-			intVal, err := s.UnpackUint64(buf)
-			if err != nil {
-				return val, err
-			}
-			val = reflect.ValueOf(intVal)
-		} else if intSize == 4 {
-			intVal, err := s.UnpackUint32(buf)
-			if err != nil {
-				return val, err
-			}
-			val = reflect.ValueOf(intVal)
-		}
-	case reflect.Uint8:
-		intVal, err := s.UnpackInt8(buf)
-		if err != nil {
-			return val, err
-		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Uint16:
-		intVal, err := s.UnpackInt16(buf)
-		if err != nil {
-			return val, err
-		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Uint32:
-		intVal, err := s.UnpackInt32(buf)
+	val := reflect.New(valType).Elem()
+	err := s.readValue(buf, val)
+	return val, err
+}
+
+func (s *Packer) readSlice(buf BPReader, f reflect.Value) error {
+	// read nil flag and find out how many items are in the slice
+	sliceType := f.Type()
+	n, isNil, chunks, err := s.readCollectionHeader(buf, sliceType)
+	if err != nil || isNil {
+		return err
+	}
+	if s.parallelChunks(chunks) {
+		sliceVal, err := s.readChunkedSlice(sliceType, buf, n, chunks)
 		if err != nil {
-			return val, err
+			return err
 		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Uint64:
-		intVal, err := s.UnpackUint64(buf)
+		f.Set(*sliceVal)
+		return nil
+	}
+	numEntries := int32(n)
+	sliceKind := sliceType.Elem().Kind()
+	switch sliceKind {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		err = s.reach()
 		if err != nil {
-			return val, err
+			return err
 		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Int32:
-		intVal, err := s.UnpackInt32(buf)
+		sliceValue := reflect.MakeSlice(sliceType, int(numEntries), int(numEntries))
+		err = s.readFixed(buf, sliceValue)
 		if err != nil {
-			return val, err
+			return err
 		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Int64:
-		intVal, err := s.UnpackInt64(buf)
+		f.Set(sliceValue)
+	case reflect.String:
+		err = s.reach()
 		if err != nil {
-			return val, err
+			return err
 		}
-		val = reflect.ValueOf(intVal)
-	case reflect.Float64:
-		floatVal, err := s.UnpackFloat64(buf)
-		if err != nil {
-			return val, err
+		strSlice := make([]string, numEntries, numEntries)
+		for i := 0; i < int(numEntries); i++ {
+			strSlice[i], err = s.UnpackString(buf)
+			if err != nil {
+				return err
+			}
 		}
-		val = reflect.ValueOf(floatVal)
-	case reflect.Float32:
-		floatVal, err := s.UnpackFloat32(buf)
+		f.Set(reflect.ValueOf(strSlice))
+	default:
+		sliceValue := reflect.MakeSlice(sliceType, int(numEntries), int(numEntries))
+		f.Set(sliceValue)
+		return s.push(frame{kind: elemsFrame, v: sliceValue, n: n, fields: s.elemFields(sliceType.Elem())})
+	}
+	return nil
+}
+
+func (s *Packer) readArray(buf BPReader, f reflect.Value) error {
+	arrayType := f.Type()
+	arrayKind := arrayType.Elem().Kind()
+	switch arrayKind {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		err := s.reach()
 		if err != nil {
-			return val, err
+			return err
 		}
-		val = reflect.ValueOf(floatVal)
-	case reflect.Bool:
-		var boolVal bool
-		err := binary.Read(buf, binary.BigEndian, &boolVal)
+		arrayValue := reflect.New(arrayType).Elem()
+		err = s.readFixed(buf, arrayValue)
 		if err != nil {
-			return val, err
+			return err
 		}
-		val = reflect.ValueOf(boolVal)
+		f.Set(arrayValue)
 	case reflect.String:
-		strVal, err := s.UnpackString(buf)
-		if err != nil {
-			return val, err
-		}
-		val = reflect.ValueOf(strVal)
-	case reflect.Struct:
-		err := s.readStruct(buf, val.Elem())
-		if err != nil {
-			return val, err
-		}
-		val = val.Elem()
-	case reflect.Slice:
-		sliceVal, err := s.UnpackSlice(valType, buf)
+		err := s.reach()
 		if err != nil {
-			return val, err
-		}
-		val = *sliceVal
-		//log.Debugf("slice type = %v", ft.Type)
-	case reflect.Map:
-		decodedMap := reflect.MakeMap(valType)
-		_, err := s.readMap(valType, buf, decodedMap)
-		if err != nil {
-			return val, err
+			return err
 		}
-		val = decodedMap
-	case reflect.Ptr:
-		var err error
-		val, err = s.readPointer(valType, buf)
-		if err != nil {
-			return val, err
+		arrayValue := reflect.New(arrayType).Elem()
+		numEntries := arrayValue.Len()
+		for i := 0; i < numEntries; i++ {
+			str, err := s.UnpackString(buf)
+			if err != nil {
+				return err
+			}
+			arrayValue.Index(i).SetString(str)
 		}
-	case reflect.Chan:
-		// do nothing with the chan and leave it nil
+		f.Set(arrayValue)
+	default:
+		f.Set(reflect.Zero(arrayType))
+		return s.push(frame{kind: elemsFrame, v: f, n: f.Len(), fields: s.elemFields(arrayType.Elem())})
 	}
-	return val, nil
+	return nil
 }
 
 /*-----------------------------------
@@ -1324,6 +1408,13 @@ func (s *Packer) UnpackString(buf BPReader) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if strLen < 0 {
+		return "", errors.New(fmt.Sprintf("invalid string length %d", strLen))
+	}
+	if r, ok := buf.(*sliceReader); ok {
+		b, err := r.next(int(strLen))
+		return string(b), unexpectedEOF(err)
+	}
 
 	strBuf := make([]byte, strLen)
 	_, err = io.ReadFull(buf, strBuf)
@@ -1334,96 +1425,39 @@ func (s *Packer) UnpackString(buf BPReader) (string, error) {
 }
 
 func (s *Packer) UnpackInt8(buf BPReader) (int8, error) {
-	var err error
-	b1, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	return int8(b1), nil
+	b, err := readByte(buf)
+	return int8(b), err
 }
 
 func (s *Packer) UnpackInt16(buf BPReader) (int16, error) {
-	var i uint16
-	var err error
-	b1, err := buf.ReadByte()
+	b, err := s.readWord(buf, 2)
 	if err != nil {
 		return 0, err
 	}
-	b2, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	i = uint16(b2) | uint16(b1)<<8
-	return int16(i), nil
+	return int16(binary.BigEndian.Uint16(b)), nil
 }
 
 func (s *Packer) UnpackInt32(buf BPReader) (int32, error) {
-	var i uint32
-	var err error
-	b1, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b2, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b3, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b4, err := buf.ReadByte()
+	b, err := s.readWord(buf, 4)
 	if err != nil {
 		return 0, err
 	}
-	i = uint32(b4) | uint32(b3)<<8 | uint32(b2)<<16 | uint32(b1)<<24
-	return int32(i), nil
+	return int32(binary.BigEndian.Uint32(b)), nil
 }
 
 func (s *Packer) UnpackInt64(buf BPReader) (int64, error) {
-	var i uint64
-	var err error
-	b1, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b2, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b3, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b4, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b5, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b6, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b7, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b8, err := buf.ReadByte()
+	b, err := s.readWord(buf, 8)
 	if err != nil {
 		return 0, err
 	}
-	i = uint64(b8) | uint64(b7)<<8 | uint64(b6)<<16 | uint64(b5)<<24 | uint64(b4)<<32 | uint64(b3)<<40 | uint64(b2)<<48 | uint64(b1)<<56
-	return int64(i), nil
+	return int64(binary.BigEndian.Uint64(b)), nil
 }
 
 func (s *Packer) UnpackInt(buf BPReader) (int, error) {
-	if intSize == 8 {
+	if s.intWidth() == 8 {
 		i, err := s.UnpackInt64(buf)
 		return int(i), err
-	} else if intSize == 4 {
+	} else if s.intWidth() == 4 {
 		i, err := s.UnpackInt32(buf)
 		return int(i), err
 	}
@@ -1431,122 +1465,181 @@ func (s *Packer) UnpackInt(buf BPReader) (int, error) {
 }
 
 func (s *Packer) UnpackUint8(buf BPReader) (uint8, error) {
-	return buf.ReadByte()
+	b, err := readByte(buf)
+	return b, err
 }
 
 func (s *Packer) UnpackUint16(buf BPReader) (uint16, error) {
-	var i uint16
-	var err error
-	b1, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b2, err := buf.ReadByte()
+	b, err := s.readWord(buf, 2)
 	if err != nil {
 		return 0, err
 	}
-	i = uint16(b2) | uint16(b1)<<8
-	return i, nil
+	return binary.BigEndian.Uint16(b), nil
 }
 
 func (s *Packer) UnpackUint32(buf BPReader) (uint32, error) {
-	var i uint32
-	var err error
-	b1, err := buf.ReadByte()
+	b, err := s.readWord(buf, 4)
 	if err != nil {
 		return 0, err
 	}
-	b2, err := buf.ReadByte()
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (s *Packer) UnpackUint64(buf BPReader) (uint64, error) {
+	b, err := s.readWord(buf, 8)
 	if err != nil {
 		return 0, err
 	}
-	b3, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (s *Packer) UnpackUint(buf BPReader) (uint, error) {
+	if s.intWidth() == 8 {
+		i, err := s.UnpackUint64(buf)
+		return uint(i), err
+	} else if s.intWidth() == 4 {
+		i, err := s.UnpackUint32(buf)
+		return uint(i), err
 	}
-	b4, err := buf.ReadByte()
+	return 0, errors.New("int must be 4 or 8 bytes depending on the system")
+}
+
+func (s *Packer) UnpackFloat64(buf BPReader) (float64, error) {
+	bits, err := s.UnpackUint64(buf)
 	if err != nil {
 		return 0, err
 	}
-	i = uint32(b4) | uint32(b3)<<8 | uint32(b2)<<16 | uint32(b1)<<24
-	return i, nil
+	f := math.Float64frombits(bits)
+	return f, err
 }
 
-func (s *Packer) UnpackUint64(buf BPReader) (uint64, error) {
-	var i uint64
-	var err error
-	b1, err := buf.ReadByte()
+func (s *Packer) UnpackFloat32(buf BPReader) (float32, error) {
+	bits, err := s.UnpackUint32(buf)
 	if err != nil {
 		return 0, err
 	}
-	b2, err := buf.ReadByte()
+	f := math.Float32frombits(bits)
+	return f, err
+}
+
+func (s *Packer) UnpackComplex64(buf BPReader) (complex64, error) {
+	r, err := s.UnpackFloat32(buf)
 	if err != nil {
 		return 0, err
 	}
-	b3, err := buf.ReadByte()
+	i, err := s.UnpackFloat32(buf)
 	if err != nil {
 		return 0, err
 	}
-	b4, err := buf.ReadByte()
+	return complex(r, i), nil
+}
+
+func (s *Packer) UnpackComplex128(buf BPReader) (complex128, error) {
+	r, err := s.UnpackFloat64(buf)
 	if err != nil {
 		return 0, err
 	}
-	b5, err := buf.ReadByte()
+	i, err := s.UnpackFloat64(buf)
 	if err != nil {
 		return 0, err
 	}
-	b6, err := buf.ReadByte()
+	return complex(r, i), nil
+}
+
+func (s *Packer) UnpackUintptr(buf BPReader) (uintptr, error) {
+	i, err := s.UnpackUint64(buf)
+	return uintptr(i), err
+}
+
+func (s *Packer) UnpackBool(buf BPReader) (bool, error) {
+	b1, err := readByte(buf)
 	if err != nil {
-		return 0, err
+		return false, err
 	}
-	b7, err := buf.ReadByte()
-	if err != nil {
-		return 0, err
+	return b1 != 0, nil
+}
+
+// UnpackBytes reads a byte slice written by PackBytes or encoded from a []byte field
+func (s *Packer) UnpackBytes(buf BPReader) ([]byte, error) {
+	n, isNil, _, err := s.readCollectionHeader(buf, bytesType)
+	if err != nil || isNil {
+		return nil, err
+	}
+	if r, ok := buf.(*sliceReader); ok {
+		// a length past the end of the message fails before anything is allocated
+		data, err := r.next(n)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return append([]byte{}, data...), nil
 	}
-	b8, err := buf.ReadByte()
+	// copy as the bytes arrive, so a corrupted length cannot make us allocate a huge buffer
+	var b bytes.Buffer
+	_, err = io.CopyN(&b, buf, int64(n))
 	if err != nil {
-		return 0, err
+		return nil, unexpectedEOF(err)
 	}
-	i = uint64(b8) | uint64(b7)<<8 | uint64(b6)<<16 | uint64(b5)<<24 | uint64(b4)<<32 | uint64(b3)<<40 | uint64(b2)<<48 | uint64(b1)<<56
-	return i, nil
+	return b.Bytes(), nil
 }
 
-func (s *Packer) UnpackUint(buf BPReader) (uint, error) {
-	if intSize == 8 {
-		i, err := s.UnpackUint64(buf)
-		return uint(i), err
-	} else if intSize == 4 {
-		i, err := s.UnpackUint32(buf)
-		return uint(i), err
+func (s *Packer) UnpackVarint(buf BPReader) (int64, error) {
+	if r, ok := buf.(*sliceReader); ok {
+		ival, n := binary.Varint(r.data[r.pos:])
+		if n <= 0 {
+			return 0, varintError(n)
+		}
+		r.pos += n
+		return ival, nil
 	}
-	return 0, errors.New("int must be 4 or 8 bytes depending on the system")
+	return binary.ReadVarint(buf)
 }
 
-func (s *Packer) UnpackFloat64(buf BPReader) (float64, error) {
-	bits, err := s.UnpackUint64(buf)
-	if err != nil {
-		return 0, err
+func (s *Packer) UnpackUvarint(buf BPReader) (uint64, error) {
+	if r, ok := buf.(*sliceReader); ok {
+		uival, n := binary.Uvarint(r.data[r.pos:])
+		if n <= 0 {
+			return 0, varintError(n)
+		}
+		r.pos += n
+		return uival, nil
 	}
-	f := math.Float64frombits(bits)
-	return f, err
+	return binary.ReadUvarint(buf)
 }
 
-func (s *Packer) UnpackFloat32(buf BPReader) (float32, error) {
-	bits, err := s.UnpackUint32(buf)
-	if err != nil {
-		return 0, err
+// varintError turns the failed result n of binary.Varint or binary.Uvarint into the error binary.ReadVarint would return
+func varintError(n int) error {
+	if n == 0 {
+		return io.ErrUnexpectedEOF
 	}
-	f := math.Float32frombits(bits)
-	return f, err
+	return errors.New("varint overflows a 64-bit integer")
 }
 
-func (s *Packer) UnpackBool(buf BPReader) (bool, error) {
-	var err error
-	b1, err := buf.ReadByte()
+// UnpackTime reads a time written by PackTime. A time packed in the local zone of this process is returned in time.Local.
+func (s *Packer) UnpackTime(buf BPReader) (time.Time, error) {
+	sec, err := s.UnpackInt64(buf)
 	if err != nil {
-		return false, err
+		return time.Time{}, err
 	}
-	return b1 != 0, nil
+	nsec, err := s.UnpackUint32(buf)
+	if err != nil {
+		return time.Time{}, unexpectedEOF(err)
+	}
+	offsetMin, err := s.UnpackInt16(buf)
+	if err != nil {
+		return time.Time{}, unexpectedEOF(err)
+	}
+	if nsec >= 1e9 {
+		return time.Time{}, errors.New(fmt.Sprintf("invalid nanoseconds %d", nsec))
+	}
+	t := time.Unix(sec, int64(nsec))
+	if offsetMin == -1 {
+		return t.UTC(), nil
+	}
+	offset := int(offsetMin) * 60
+	if _, localOffset := t.Zone(); localOffset == offset {
+		return t, nil
+	}
+	return t.In(time.FixedZone("", offset)), nil
 }
 
 func (s *Packer) UnpackStruct(buf BPReader, i interface{}) error {
@@ -1569,159 +1662,34 @@ func (s *Packer) UnpackStruct(buf BPReader, i interface{}) error {
 }
 
 func (s *Packer) UnpackArray(arrayType reflect.Type, buf BPReader) (*reflect.Value, error) {
-	// first find out how many items are in the slice
-	arrayKind := arrayType.Elem().Kind()
-	var err error
-	switch arrayKind {
-	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Bool, reflect.Float32, reflect.Float64:
-		arrayValue := reflect.New(arrayType)
-		err = binary.Read(buf, binary.BigEndian, arrayValue.Interface())
-		if err != nil {
-			return nil, err
-		}
-		a := arrayValue.Elem()
-		return &a, nil
-	case reflect.Uint8:
-		arrayValue := reflect.New(arrayType).Elem()
-		numEntries := arrayValue.Len()
-		for i := 0; i < numEntries; i++ {
-			bval, err := s.UnpackUint8(buf)
-			if err != nil {
-				return nil, err
-			}
-			arrayValue.Index(i).SetUint(uint64(bval))
-
-		}
-		return &arrayValue, nil
-	case reflect.Int:
-		arrayValue := reflect.New(arrayType).Elem()
-		numEntries := arrayValue.Len()
-		if intSize == 8 {
-			var ival int64
-			for i := 0; i < numEntries; i++ {
-				ival, err = s.UnpackInt64(buf)
-				if err != nil {
-					return nil, err
-				}
-				arrayValue.Index(i).SetInt(ival)
-			}
-		} else if intSize == 4 {
-			var ival int32
-			for i := 0; i < numEntries; i++ {
-				ival, err = s.UnpackInt32(buf)
-				if err != nil {
-					return nil, err
-				}
-				arrayValue.Index(i).SetInt(int64(ival))
-			}
-		}
-		return &arrayValue, nil
-	case reflect.String:
-		arrayValue := reflect.New(arrayType).Elem()
-		numEntries := arrayValue.Len()
-		for i := 0; i < numEntries; i++ {
-			str, err := s.UnpackString(buf)
-			if err != nil {
-				return nil, err
-			}
-			arrayValue.Index(i).SetString(str)
-		}
-		return &arrayValue, nil
-	case reflect.Struct:
-		arrayValue := reflect.New(arrayType).Elem()
-		numEntries := arrayValue.Len()
-		for i := 0; i < numEntries; i++ {
-			err = s.readStruct(buf, arrayValue.Index(i))
-			if err != nil {
-				return nil, err
-			}
-		}
-		return &arrayValue, nil
-	default:
-		return nil, errors.New("unknown slice type")
+	arrayValue := reflect.New(arrayType).Elem()
+	err := s.readValue(buf, arrayValue)
+	if err != nil {
+		return nil, err
 	}
+	return &arrayValue, nil
 }
 
 func (s *Packer) UnpackSlice(sliceType reflect.Type, buf BPReader) (*reflect.Value, error) {
-	// read nil flag
-	isNil, err := s.UnpackBool(buf)
-	if isNil {
-		return nil, nil
-	}
-	// first find out how many items are in the slice
-	numEntries, err := s.UnpackInt32(buf)
+	sliceValue := reflect.New(sliceType).Elem()
+	err := s.readValue(buf, sliceValue)
 	if err != nil {
 		return nil, err
 	}
-	sliceKind := sliceType.Elem().Kind()
-	switch sliceKind {
-	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Bool, reflect.Float32, reflect.Float64:
-		sliceValue := reflect.MakeSlice(sliceType, int(numEntries), int(numEntries))
-		err = binary.Read(buf, binary.BigEndian, sliceValue.Interface())
-		if err != nil {
-			return nil, err
-		}
-		return &sliceValue, nil
-	case reflect.Uint8:
-		b := make([]byte, int(numEntries), int(numEntries))
-		_, err = io.ReadFull(buf, b)
-		if err != nil {
-			return nil, err
-		}
-		reflectedB := reflect.ValueOf(b)
-		return &reflectedB, nil
-	case reflect.Int:
-		intSlice := make([]int, numEntries, numEntries)
-		if intSize == 8 {
-			var ival int64
-			for i := 0; i < int(numEntries); i++ {
-				ival, err = s.UnpackInt64(buf)
-				if err != nil {
-					return nil, err
-				}
-				intSlice[i] = int(ival)
-			}
-		} else if intSize == 4 {
-			var ival int32
-			for i := 0; i < int(numEntries); i++ {
-				ival, err = s.UnpackInt32(buf)
-				if err != nil {
-					return nil, err
-				}
-				intSlice[i] = int(ival)
-			}
-		}
-		sliceVal := reflect.ValueOf(intSlice)
-		return &sliceVal, nil
-	case reflect.String:
-		strSlice := make([]string, numEntries, numEntries)
-		for i := 0; i < int(numEntries); i++ {
-			strSlice[i], err = s.UnpackString(buf)
-			if err != nil {
-				return nil, err
-			}
-		}
-		sliceVal := reflect.ValueOf(strSlice)
-		return &sliceVal, nil
-	case reflect.Struct:
-		sliceValue := reflect.MakeSlice(sliceType, int(numEntries), int(numEntries))
-		for i := 0; i < int(numEntries); i++ {
-			err = s.readStruct(buf, sliceValue.Index(i))
-			if err != nil {
-				return nil, err
-			}
-		}
-		return &sliceValue, nil
-	default:
-		return nil, errors.New("unknown slice type")
+	if sliceValue.IsNil() {
+		return nil, nil
 	}
+	return &sliceValue, nil
 }
 
 func (s *Packer) UnpackMap(mapType reflect.Type, buf BPReader) (*reflect.Value, error) {
-	decodedMap := reflect.MakeMap(mapType)
-	_, err := s.readMap(mapType, buf, decodedMap)
+	decodedMap := reflect.New(mapType).Elem()
+	err := s.readValue(buf, decodedMap)
 	if err != nil {
 		return nil, err
 	}
+	if decodedMap.IsNil() {
+		decodedMap = reflect.MakeMap(mapType)
+	}
 	return &decodedMap, nil
 }
@@ -2,11 +2,13 @@ package bytepack
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
 	"reflect"
+	"runtime"
 	"testing"
 )
 
@@ -564,3 +566,205 @@ func Benchmark_AcceptMsgGob(b *testing.B) {
 		}
 	}
 }
+
+func newFloat64Slice(n int) []float64 {
+	f := make([]float64, n)
+	for i := range f {
+		f[i] = float64(i) / 3
+	}
+	return f
+}
+
+func Benchmark_EncodeSliceFloat64(b *testing.B) {
+	s := NewPacker()
+	floats := reflect.ValueOf(newFloat64Slice(4096))
+	b.SetBytes(8 * 4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := s.encodeSlice(floats)
+		if err != nil {
+			fmt.Printf("err=%v\n", err)
+		}
+		s.w.Reset()
+	}
+}
+
+// Benchmark_EncodeSliceFloat64BinaryWrite encodes the same slice the way encodeSlice did before the bulk path
+func Benchmark_EncodeSliceFloat64BinaryWrite(b *testing.B) {
+	w := new(bytes.Buffer)
+	floats := newFloat64Slice(4096)
+	b.SetBytes(8 * 4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := binary.Write(w, binary.BigEndian, floats)
+		if err != nil {
+			fmt.Printf("err=%v\n", err)
+		}
+		w.Reset()
+	}
+}
+
+func Benchmark_ReadSliceFloat64(b *testing.B) {
+	s := NewPacker()
+	err := s.encodeSlice(reflect.ValueOf(newFloat64Slice(4096)))
+	if err != nil {
+		panic(err)
+	}
+	bufBytes := s.w.Bytes()
+	sliceType := reflect.TypeOf([]float64{})
+	b.SetBytes(8 * 4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := s.UnpackSlice(sliceType, bytes.NewReader(bufBytes))
+		if err != nil {
+			fmt.Printf("err=%v\n", err)
+		}
+	}
+}
+
+// Benchmark_ReadSliceFloat64BinaryRead decodes the same slice the way UnpackSlice did before the bulk path
+func Benchmark_ReadSliceFloat64BinaryRead(b *testing.B) {
+	w := new(bytes.Buffer)
+	err := binary.Write(w, binary.BigEndian, newFloat64Slice(4096))
+	if err != nil {
+		panic(err)
+	}
+	bufBytes := w.Bytes()
+	b.SetBytes(8 * 4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		floats := make([]float64, 4096)
+		err := binary.Read(bytes.NewReader(bufBytes), binary.BigEndian, floats)
+		if err != nil {
+			fmt.Printf("err=%v\n", err)
+		}
+	}
+}
+
+func Benchmark_EncodeArrayInt32(b *testing.B) {
+	s := NewPacker()
+	var arr [1024]int32
+	for i := range arr {
+		arr[i] = int32(i * -7)
+	}
+	v := reflect.ValueOf(&arr).Elem()
+	b.SetBytes(4 * 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := s.encodeArray(v)
+		if err != nil {
+			fmt.Printf("err=%v\n", err)
+		}
+		s.w.Reset()
+	}
+}
+
+func Benchmark_UnpackInt64(b *testing.B) {
+	s := NewPacker()
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 1024)
+	buf := bytes.NewReader(data)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if buf.Len() == 0 {
+			buf.Reset(data)
+		}
+		_, err := s.UnpackInt64(buf)
+		if err != nil {
+			fmt.Printf("err=%v\n", err)
+		}
+	}
+}
+
+func Benchmark_UnpackFromSlice(b *testing.B) {
+	s := NewPacker()
+	buf, err := s.Pack(newSnapshotMsg(100))
+	if err != nil {
+		panic(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var m snapshotMsg
+		err = s.Unpack(buf, &m)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Benchmark_UnpackFromStream decodes the same message through the BPReader interface
+func Benchmark_UnpackFromStream(b *testing.B) {
+	s := NewPacker()
+	buf, err := s.Pack(newSnapshotMsg(100))
+	if err != nil {
+		panic(err)
+	}
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var m snapshotMsg
+		err = s.UnpackFromReader(bytes.NewBuffer(buf), &m)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func benchmarkBytePackParallel(b *testing.B, bp *BytePack) {
+	a := person{
+		Name:   "Tester",
+		Age:    30,
+		Height: 5.25,
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			buf, err := bp.Pack(a)
+			if err != nil {
+				panic(err)
+			}
+			var a2 person
+			err = bp.Unpack(buf, &a2)
+			if err != nil {
+				panic(err)
+			}
+		}
+	})
+}
+
+func Benchmark_BytePackParallelFixedPool(b *testing.B) {
+	benchmarkBytePackParallel(b, NewBytePack(runtime.GOMAXPROCS(0)))
+}
+
+func Benchmark_BytePackParallelElasticPool(b *testing.B) {
+	benchmarkBytePackParallel(b, NewBytePack(runtime.GOMAXPROCS(0), WithElasticPool(0)))
+}
+
+func benchmarkLargeMessage(b *testing.B, bp *BytePack) {
+	c := newCensus(100000)
+	c.Parents = nil
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, err := bp.Pack(c)
+		if err != nil {
+			panic(err)
+		}
+		var c2 census
+		err = bp.Unpack(buf, &c2)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func Benchmark_LargeMessageSequential(b *testing.B) {
+	benchmarkLargeMessage(b, NewBytePack(runtime.GOMAXPROCS(0)))
+}
+
+func Benchmark_LargeMessageParallelChunks(b *testing.B) {
+	benchmarkLargeMessage(b, NewBytePack(runtime.GOMAXPROCS(0), WithParallelChunks(0, 0)))
+}
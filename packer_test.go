@@ -634,6 +634,27 @@ func TestPacker_EncodeReflectWithPointerLoop(t *testing.T) {
 	fmt.Printf("a2=%+v\n", a2)
 }
 
+func TestPacker_EncodeReflectWithSharedPointer(t *testing.T) {
+	type sharedPtrs struct {
+		A *person
+		B *person
+		C []*person
+	}
+	s := NewPacker()
+	p := &person{Name: "Tester", Age: 30}
+
+	buf, err := s.Pack(sharedPtrs{A: p, B: p, C: []*person{p, p}})
+	assert.NoError(t, err)
+
+	var a2 sharedPtrs
+	err = s.Unpack(buf, &a2)
+	assert.NoError(t, err)
+	assert.Equal(t, "Tester", a2.A.Name)
+	assert.Same(t, a2.A, a2.B)
+	assert.Same(t, a2.A, a2.C[0])
+	assert.Same(t, a2.A, a2.C[1])
+}
+
 func TestPacker_EncodeReflectWithPointerLoopAndPointerEncode(t *testing.T) {
 	s := NewPacker()
 
@@ -1091,3 +1112,187 @@ func TestPacker_EncodeReflectOverSlowTcp(t *testing.T) {
 	assert.Equal(t, a.HairColor, a2.HairColor)
 	assert.Equal(t, a.Weight, a2.Weight)
 }
+
+func TestPacker_EncodeReflectSkipsUnexportedFields(t *testing.T) {
+	s := NewPacker()
+	type fooUnexported struct {
+		Name   string
+		secret string
+		Age    int32
+	}
+	a := fooUnexported{
+		Name:   "Tester",
+		secret: "hidden",
+		Age:    30,
+	}
+
+	buf, err := s.Pack(a)
+	assert.NoError(t, err)
+
+	var a2 fooUnexported
+	err = s.Unpack(buf, &a2)
+	assert.NoError(t, err)
+	assert.Equal(t, a.Name, a2.Name)
+	assert.Equal(t, a.Age, a2.Age)
+	assert.Equal(t, "", a2.secret)
+}
+
+func TestPacker_EncodeReflectIncludesUnexportedFields(t *testing.T) {
+	s := NewPacker(WithUnexportedFields(IncludeUnexported))
+	type fooUnexportedInner struct {
+		nums []int32
+	}
+	type fooUnexported struct {
+		Name   string
+		secret string
+		inner  fooUnexportedInner
+		m      map[string]int
+	}
+	a := fooUnexported{
+		Name:   "Tester",
+		secret: "hidden",
+		inner:  fooUnexportedInner{nums: []int32{1, 2, 3}},
+		m:      map[string]int{"a": 1},
+	}
+
+	buf, err := s.Pack(a)
+	assert.NoError(t, err)
+
+	var a2 fooUnexported
+	err = s.Unpack(buf, &a2)
+	assert.NoError(t, err)
+	assert.Equal(t, a, a2)
+}
+
+func TestPacker_EncodeReflectWithUnsupportedKind(t *testing.T) {
+	s := NewPacker()
+	type fooFunc struct {
+		Name string
+		F    func()
+	}
+	a := fooFunc{
+		Name: "Tester",
+		F:    func() {},
+	}
+
+	buf, err := s.Pack(a)
+	assert.ErrorIs(t, err, ErrUnsupportedKind)
+	assert.Nil(t, buf)
+	assert.Equal(t, 0, s.w.Len())
+
+	// a payload that would otherwise decode fine is rejected before reading any bytes
+	type fooName struct {
+		Name string
+	}
+	buf, err = s.Pack(fooName{Name: "Tester"})
+	assert.NoError(t, err)
+	var a2 fooFunc
+	reader := bytes.NewBuffer(buf)
+	err = s.UnpackFromReader(reader, &a2)
+	assert.ErrorIs(t, err, ErrUnsupportedKind)
+	assert.Equal(t, len(buf), reader.Len())
+}
+
+func TestPacker_EncodeRootChannel(t *testing.T) {
+	s := NewPacker()
+	ch := make(chan int)
+	buf, err := s.Pack(ch)
+	assert.ErrorIs(t, err, ErrUnsupportedKind)
+	assert.Nil(t, buf)
+	_, err = s.Pack(&ch)
+	assert.Error(t, err)
+	_, err = s.Size(ch)
+	assert.ErrorIs(t, err, ErrUnsupportedKind)
+	var ch2 chan int
+	assert.Error(t, s.Unpack([]byte{0}, &ch2))
+
+	// channel fields are still skipped
+	type fooChan struct {
+		Name string
+		C    chan int
+	}
+	buf, err = s.Pack(fooChan{Name: "Tester", C: ch})
+	assert.NoError(t, err)
+	var a fooChan
+	assert.NoError(t, s.Unpack(buf, &a))
+	assert.Equal(t, fooChan{Name: "Tester"}, a)
+}
+
+func TestPacker_EncodeReflectWithUnsupportedInterfaceValue(t *testing.T) {
+	s := NewPacker()
+	type fooIface struct {
+		Name string
+		Val  interface{}
+	}
+
+	_, err := s.Pack(fooIface{Name: "Tester", Val: 5})
+	assert.Error(t, err)
+	_, err = s.Pack(fooIface{Name: "Tester", Val: complex(1, 2)})
+	assert.Error(t, err)
+	assert.Equal(t, 0, s.w.Len())
+}
+
+func TestPacker_EncodeReflectWithNestedSlicesAndPointers(t *testing.T) {
+	s := NewPacker()
+	type fooNested struct {
+		Grid  [][]string
+		Ptrs  []*person
+		Maps  []map[uint8]uint
+		Bytes [2][]byte
+	}
+	a := fooNested{
+		Grid:  [][]string{{"a", "b"}, nil, {"c"}},
+		Ptrs:  []*person{{Name: "p1", Age: 1}, nil},
+		Maps:  []map[uint8]uint{{1: 10, 2: 20}},
+		Bytes: [2][]byte{{1, 2}, {3}},
+	}
+
+	buf, err := s.Pack(a)
+	assert.NoError(t, err)
+
+	var a2 fooNested
+	err = s.Unpack(buf, &a2)
+	assert.NoError(t, err)
+	assert.Equal(t, a, a2)
+}
+
+func TestPacker_EncodeReflectWithComplexAndUintptr(t *testing.T) {
+	s := NewPacker()
+	type signal struct {
+		C64      complex64
+		C128     complex128
+		Ptr      uintptr
+		Samples  []complex128
+		Window   [3]complex64
+		Addrs    []uintptr
+		ByFreq   map[complex64]complex128
+		ByAddr   map[uintptr][]complex64
+		Spectrum [2]uintptr
+	}
+	a := signal{
+		C64:      complex(1.5, -2.25),
+		C128:     complex(3.125, 4.5),
+		Ptr:      uintptr(0xdeadbeef),
+		Samples:  []complex128{complex(1, 2), complex(-3, 4), 0},
+		Window:   [3]complex64{complex(1, 1), complex(2, 2), complex(3, 3)},
+		Addrs:    []uintptr{1, 2, 3},
+		ByFreq:   map[complex64]complex128{complex(1, 0): complex(0, 1)},
+		ByAddr:   map[uintptr][]complex64{42: {complex(4, 2)}},
+		Spectrum: [2]uintptr{7, 8},
+	}
+
+	buf, err := s.Pack(a)
+	assert.NoError(t, err)
+
+	var a2 signal
+	err = s.Unpack(buf, &a2)
+	assert.NoError(t, err)
+	assert.Equal(t, a, a2)
+
+	buf, err = s.Pack(a.Samples)
+	assert.NoError(t, err)
+	var samples []complex128
+	err = s.Unpack(buf, &samples)
+	assert.NoError(t, err)
+	assert.Equal(t, a.Samples, samples)
+}
@@ -0,0 +1,75 @@
+package bytepack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Pointer headers are a uint16 whose top bit is the nil flag and whose other 15 bits hold the pointer ID.
+// IDs that do not fit in 15 bits follow the header wideIDHeader as a uvarint.
+const (
+	nilPointerHeader = 0x8000
+	wideIDHeader     = 0xFFFF
+	// maxNarrowID is the largest pointer ID that fits in the header itself
+	maxNarrowID = 0x7FFF
+	// maxPointerID is the largest pointer ID a message can hold
+	maxPointerID = math.MaxUint32
+)
+
+// ErrTooManyPointers is returned when a value holds more distinct pointers than a message can identify
+var ErrTooManyPointers = errors.New("too many distinct pointers")
+
+// nextPointerID hands out the ID of a pointer seen for the first time
+func (s *Packer) nextPointerID() (uint32, error) {
+	if s.ptrIdCounter == maxPointerID {
+		return 0, fmt.Errorf("%w: more than %d", ErrTooManyPointers, maxPointerID-1)
+	}
+	id := s.ptrIdCounter
+	s.ptrIdCounter++
+	return id, nil
+}
+
+// packPointerID writes the header of a non-nil pointer with the given ID
+func (s *Packer) packPointerID(id uint32) error {
+	if id <= maxNarrowID {
+		return s.PackUint16(uint16(id))
+	}
+	err := s.PackUint16(wideIDHeader)
+	if err != nil {
+		return err
+	}
+	return s.PackUvarint(uint64(id))
+}
+
+// pointerIDSize returns the size of the header of a non-nil pointer with the given ID
+func pointerIDSize(id uint32) int {
+	if id <= maxNarrowID {
+		return 2
+	}
+	var b [binary.MaxVarintLen32]byte
+	return 2 + binary.PutUvarint(b[:], uint64(id))
+}
+
+// unpackPointerHeader reads a pointer header, returning the ID of the pointer, or true if the pointer is nil
+func (s *Packer) unpackPointerHeader(buf BPReader) (uint32, bool, error) {
+	header, err := s.UnpackUint16(buf)
+	if err != nil {
+		return 0, false, err
+	}
+	if header == wideIDHeader {
+		id, err := s.UnpackUvarint(buf)
+		if err != nil {
+			return 0, false, err
+		}
+		if id <= maxNarrowID || id > maxPointerID {
+			return 0, false, errors.New(fmt.Sprintf("invalid pointer id %d", id))
+		}
+		return uint32(id), false, nil
+	}
+	if header&nilPointerHeader != 0 {
+		return 0, true, nil
+	}
+	return uint32(header), false, nil
+}
@@ -0,0 +1,112 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+type logSnapshot struct {
+	Entries []*person
+	// Recent shares its pointers with Entries
+	Recent []*person
+}
+
+func newLogSnapshot(n int) logSnapshot {
+	snap := logSnapshot{}
+	for i := 0; i < n; i++ {
+		snap.Entries = append(snap.Entries, &person{Name: "Tester", Age: int32(i)})
+	}
+	for i := n - 1; i >= 0; i -= 1000 {
+		snap.Recent = append(snap.Recent, snap.Entries[i])
+	}
+	return snap
+}
+
+func TestPacker_ManyPointers(t *testing.T) {
+	p := NewPacker()
+	snap := newLogSnapshot(100000)
+	packed, err := p.Pack(&snap)
+	assert.NoError(t, err)
+
+	size, err := p.Size(&snap)
+	assert.NoError(t, err)
+	assert.Equal(t, len(packed), size)
+
+	var snap2 logSnapshot
+	assert.NoError(t, p.Unpack(packed, &snap2))
+	assert.Equal(t, snap, snap2)
+	// pointers past the 15-bit IDs keep their identity
+	assert.Same(t, snap2.Entries[99999], snap2.Recent[0])
+	assert.Same(t, snap2.Entries[40999], snap2.Recent[59])
+	assert.Same(t, snap2.Entries[999], snap2.Recent[99])
+
+	assert.NoError(t, p.Validate(packed, reflect.TypeOf(snap2)))
+	assert.NoError(t, p.Skip(bytes.NewBuffer(packed), reflect.TypeOf(snap2)))
+
+	var snap3 logSnapshot
+	assert.NoError(t, p.UnpackInto(packed, &snap3, MergeOverwrite))
+	assert.Same(t, snap3.Entries[99999], snap3.Recent[0])
+
+	var recent logSnapshot
+	assert.NoError(t, p.UnpackFields(packed, &recent, "Recent"))
+	assert.Equal(t, snap.Recent, recent.Recent)
+}
+
+func TestPacker_LongLinkedList(t *testing.T) {
+	p := NewPacker()
+	head := newList(200000)
+	packed, err := p.Pack(head)
+	assert.NoError(t, err)
+	var head2 listNode
+	assert.NoError(t, p.Unpack(packed, &head2))
+	assert.Equal(t, 200000, listLen(&head2))
+}
+
+func TestPacker_PointerHeaders(t *testing.T) {
+	p := NewPacker()
+	assert.NoError(t, p.packPointerID(1))
+	assert.NoError(t, p.packPointerID(maxNarrowID))
+	assert.NoError(t, p.packPointerID(maxNarrowID+1))
+	assert.NoError(t, p.packPointerID(maxPointerID))
+	assert.NoError(t, p.PackUint16(nilPointerHeader))
+	packed := append([]byte{}, p.w.Bytes()...)
+	p.w.Reset()
+	// small IDs keep the two-byte header
+	assert.Equal(t, []byte{0x00, 0x01, 0x7F, 0xFF, 0xFF, 0xFF, 0x80, 0x80, 0x02}, packed[:9])
+	assert.Equal(t, 2+3, pointerIDSize(maxNarrowID+1))
+	assert.Equal(t, 2+5, pointerIDSize(maxPointerID))
+
+	buf := newSliceReader(packed)
+	for _, want := range []uint32{1, maxNarrowID, maxNarrowID + 1, maxPointerID} {
+		id, isNil, err := p.unpackPointerHeader(buf)
+		assert.NoError(t, err)
+		assert.False(t, isNil)
+		assert.Equal(t, want, id)
+	}
+	_, isNil, err := p.unpackPointerHeader(buf)
+	assert.NoError(t, err)
+	assert.True(t, isNil)
+
+	// small IDs must not use the wide header
+	_, _, err = p.unpackPointerHeader(newSliceReader([]byte{0xFF, 0xFF, 0x05}))
+	assert.Error(t, err)
+	_, _, err = p.unpackPointerHeader(newSliceReader([]byte{0xFF, 0xFF, 0x80, 0x80, 0x80, 0x80, 0x10}))
+	assert.Error(t, err)
+}
+
+func TestPacker_TooManyPointers(t *testing.T) {
+	p := NewPacker()
+	p.ptrIdCounter = maxPointerID - 1
+	id, err := p.nextPointerID()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(maxPointerID-1), id)
+	_, err = p.nextPointerID()
+	assert.ErrorIs(t, err, ErrTooManyPointers)
+
+	// the counter starts over with every message
+	snap := newLogSnapshot(10)
+	_, err = p.Pack(&snap)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,240 @@
+package bytepack
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolStats
+/*
+   PoolStats describes how busy the packers of a BytePack are, to help with sizing the pool.
+*/
+type PoolStats struct {
+	// InUse is the number of packers currently taken from the pool
+	InUse int
+	// Created is the number of packers created so far, including those an elastic pool has dropped since
+	Created int
+	// Waits counts the times a caller had to wait for a free packer
+	Waits uint64
+	// WaitTime is the total time callers spent waiting for a free packer
+	WaitTime time.Duration
+}
+
+// packerPool hands out the packers of a BytePack
+type packerPool interface {
+	// get takes a packer, waiting until one is free or ctx is done
+	get(ctx context.Context) (*Packer, error)
+	// tryGet takes a packer only if one is free right away
+	tryGet() (*Packer, bool)
+	put(s *Packer)
+	stats() PoolStats
+}
+
+// WithElasticPool
+/*
+   WithElasticPool replaces the fixed set of packers with a pool that grows under load. The numPackers given to
+   NewBytePack are created upfront and always kept. More packers are created when all of them are busy, up to
+   maxPackers in use at once (0 for no limit), and the extra ones are dropped by the garbage collector once idle.
+   Idle packers are kept in per-processor free lists, so concurrent callers do not contend on a single channel.
+*/
+func WithElasticPool(maxPackers int) BytePackOption {
+	return func(p *BytePack) {
+		p.elastic = true
+		p.maxPackers = maxPackers
+	}
+}
+
+// Stats returns the current statistics of the packer pool
+func (p *BytePack) Stats() PoolStats {
+	return p.pool.stats()
+}
+
+func (p *BytePack) get() *Packer {
+	// a context that is never done makes get wait for as long as it takes
+	s, _ := p.pool.get(context.Background())
+	return s
+}
+
+func (p *BytePack) put(s *Packer) {
+	p.pool.put(s)
+}
+
+// poolCounters are the statistics both pools keep
+type poolCounters struct {
+	inUse     atomic.Int64
+	created   atomic.Int64
+	waits     atomic.Uint64
+	waitNanos atomic.Int64
+}
+
+func (c *poolCounters) stats() PoolStats {
+	return PoolStats{
+		InUse:    int(c.inUse.Load()),
+		Created:  int(c.created.Load()),
+		Waits:    c.waits.Load(),
+		WaitTime: time.Duration(c.waitNanos.Load()),
+	}
+}
+
+// wait records a wait that started at start
+func (c *poolCounters) wait(start time.Time) {
+	c.waits.Add(1)
+	c.waitNanos.Add(int64(time.Since(start)))
+}
+
+// fixedPool keeps a fixed number of packers in a channel
+type fixedPool struct {
+	packers chan *Packer
+	poolCounters
+}
+
+func newFixedPool(numPackers int, newPacker func() *Packer) *fixedPool {
+	f := &fixedPool{packers: make(chan *Packer, numPackers)}
+	for i := 0; i < numPackers; i++ {
+		f.packers <- newPacker()
+	}
+	f.created.Store(int64(numPackers))
+	return f
+}
+
+func (f *fixedPool) get(ctx context.Context) (*Packer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s, ok := f.tryGet(); ok {
+		return s, nil
+	}
+	start := time.Now()
+	defer f.wait(start)
+	select {
+	case s := <-f.packers:
+		f.inUse.Add(1)
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *fixedPool) tryGet() (*Packer, bool) {
+	select {
+	case s := <-f.packers:
+		f.inUse.Add(1)
+		return s, true
+	default:
+		return nil, false
+	}
+}
+
+func (f *fixedPool) put(s *Packer) {
+	f.inUse.Add(-1)
+	f.packers <- s
+}
+
+// elasticPool creates packers on demand and keeps idle ones in a sync.Pool, whose free lists are per processor.
+// The packers of the reserve are never dropped, so the pool does not shrink below minIdle.
+type elasticPool struct {
+	newPacker func() *Packer
+	idle      sync.Pool
+
+	reserveMu  sync.Mutex
+	reserve    []*Packer
+	reserveLen atomic.Int64
+	minIdle    int
+
+	// tokens limits the packers in use when the pool is bounded, and is nil otherwise
+	tokens chan struct{}
+	poolCounters
+}
+
+func newElasticPool(minIdle int, maxPackers int, newPacker func() *Packer) *elasticPool {
+	e := &elasticPool{newPacker: newPacker, minIdle: minIdle}
+	if maxPackers > 0 {
+		e.tokens = make(chan struct{}, maxPackers)
+	}
+	for i := 0; i < minIdle; i++ {
+		e.reserve = append(e.reserve, newPacker())
+	}
+	e.reserveLen.Store(int64(minIdle))
+	e.created.Store(int64(minIdle))
+	return e
+}
+
+func (e *elasticPool) get(ctx context.Context) (*Packer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if e.tokens != nil {
+		select {
+		case e.tokens <- struct{}{}:
+		default:
+			start := time.Now()
+			select {
+			case e.tokens <- struct{}{}:
+				e.wait(start)
+			case <-ctx.Done():
+				e.wait(start)
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return e.take(), nil
+}
+
+func (e *elasticPool) tryGet() (*Packer, bool) {
+	if e.tokens != nil {
+		select {
+		case e.tokens <- struct{}{}:
+		default:
+			return nil, false
+		}
+	}
+	return e.take(), true
+}
+
+// take hands out an idle packer, or a new one if none is idle
+func (e *elasticPool) take() *Packer {
+	e.inUse.Add(1)
+	if s, ok := e.idle.Get().(*Packer); ok {
+		return s
+	}
+	if e.reserveLen.Load() > 0 {
+		e.reserveMu.Lock()
+		if n := len(e.reserve); n > 0 {
+			s := e.reserve[n-1]
+			e.reserve = e.reserve[:n-1]
+			e.reserveLen.Store(int64(n - 1))
+			e.reserveMu.Unlock()
+			return s
+		}
+		e.reserveMu.Unlock()
+	}
+	e.created.Add(1)
+	return e.newPacker()
+}
+
+func (e *elasticPool) put(s *Packer) {
+	e.inUse.Add(-1)
+	if !e.refillReserve(s) {
+		e.idle.Put(s)
+	}
+	if e.tokens != nil {
+		<-e.tokens
+	}
+}
+
+// refillReserve keeps s in the reserve if the reserve is short of packers
+func (e *elasticPool) refillReserve(s *Packer) bool {
+	if e.reserveLen.Load() >= int64(e.minIdle) {
+		return false
+	}
+	e.reserveMu.Lock()
+	defer e.reserveMu.Unlock()
+	if len(e.reserve) >= e.minIdle {
+		return false
+	}
+	e.reserve = append(e.reserve, s)
+	e.reserveLen.Store(int64(len(e.reserve)))
+	return true
+}
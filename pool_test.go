@@ -0,0 +1,109 @@
+package bytepack
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBytePack_FixedPoolStats(t *testing.T) {
+	bp := NewBytePack(2)
+	assert.Equal(t, PoolStats{Created: 2}, bp.Stats())
+
+	a, b := bp.get(), bp.get()
+	assert.Equal(t, 2, bp.Stats().InUse)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		bp.put(a)
+	}()
+	c := bp.get()
+	stats := bp.Stats()
+	assert.Equal(t, uint64(1), stats.Waits)
+	assert.GreaterOrEqual(t, stats.WaitTime, 5*time.Millisecond)
+
+	bp.put(b)
+	bp.put(c)
+	assert.Equal(t, 0, bp.Stats().InUse)
+	assert.Equal(t, 2, bp.Stats().Created)
+}
+
+func TestBytePack_ElasticPoolGrows(t *testing.T) {
+	bp := NewBytePack(1, WithElasticPool(0))
+	var taken []*Packer
+	for i := 0; i < 5; i++ {
+		s, err := bp.tryAcquire()
+		assert.NoError(t, err)
+		taken = append(taken, s)
+	}
+	stats := bp.Stats()
+	assert.Equal(t, 5, stats.InUse)
+	assert.Equal(t, 5, stats.Created)
+	assert.Equal(t, uint64(0), stats.Waits)
+
+	for _, s := range taken {
+		bp.put(s)
+	}
+	assert.Equal(t, 0, bp.Stats().InUse)
+
+	// the reserve survives garbage collection, so the pool never shrinks below numPackers
+	runtime.GC()
+	runtime.GC()
+	bp.put(bp.get())
+	assert.Equal(t, 5, bp.Stats().Created)
+}
+
+func TestBytePack_ElasticPoolIsBounded(t *testing.T) {
+	bp := NewBytePack(1, WithElasticPool(2))
+	a := person{Name: "Tester", Age: 30, Height: 5.25}
+	s1, s2 := bp.get(), bp.get()
+
+	_, err := bp.TryPack(a)
+	assert.ErrorIs(t, err, ErrNoPackerAvailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = bp.PackContext(ctx, a)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, uint64(1), bp.Stats().Waits)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		bp.put(s1)
+	}()
+	packed, err := bp.Pack(a)
+	assert.NoError(t, err)
+	bp.put(s2)
+
+	var a2 person
+	assert.NoError(t, bp.Unpack(packed, &a2))
+	assert.Equal(t, a, a2)
+	stats := bp.Stats()
+	assert.Equal(t, 0, stats.InUse)
+	assert.Equal(t, 2, stats.Created)
+	assert.Equal(t, uint64(2), stats.Waits)
+}
+
+func TestBytePack_ElasticPoolConcurrent(t *testing.T) {
+	for _, bp := range []*BytePack{NewBytePack(2, WithElasticPool(4)), NewBytePack(2, WithElasticPool(0)), NewBytePack(2)} {
+		a := person{Name: "Tester", Age: 30, Height: 5.25}
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					packed, err := bp.Pack(a)
+					assert.NoError(t, err)
+					var a2 person
+					assert.NoError(t, bp.Unpack(packed, &a2))
+					assert.Equal(t, a, a2)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 0, bp.Stats().InUse)
+	}
+}
@@ -0,0 +1,77 @@
+package bytepack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// UnpackFields
+/*
+   UnpackFields decodes only the named top-level fields of the struct obj points to, and skips over the rest
+   of the message without decoding it. Fields that are not named are left untouched, and decoding stops
+   right after the last named field.
+*/
+func (s *Packer) UnpackFields(data []byte, obj interface{}, fields ...string) error {
+	s.idstoptr = make(map[uint32]*decodingPtr, 0)
+	if _, ok := obj.(Packable); ok {
+		return errors.New("cannot unpack selected fields of a Packable")
+	}
+	v := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("must pass a pointer to a struct")
+	}
+	t := v.Elem().Type()
+	err := s.checkType(t)
+	if err != nil {
+		return err
+	}
+	structFields := s.structFields(t)
+	selected, last, err := s.selectFields(t, structFields, fields)
+	if err != nil {
+		return err
+	}
+
+	buf := newSliceReader(data)
+	notNil, err := s.readRootHeader(buf, v)
+	if err != nil || !notNil {
+		return err
+	}
+
+	objVal := v.Elem()
+	for i := 0; i <= last; i++ {
+		fi := structFields[i]
+		if selected[i] {
+			err = s.readStructField(buf, s.field(objVal, fi), fi)
+		} else {
+			err = s.skipField(t, fi, buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectFields marks the encoded fields of t with the given names and returns the position of the last one
+func (s *Packer) selectFields(t reflect.Type, structFields []fieldInfo, names []string) ([]bool, int, error) {
+	selected := make([]bool, len(structFields))
+	last := -1
+	for _, name := range names {
+		found := false
+		for i, fi := range structFields {
+			if t.Field(fi.index).Name == name {
+				selected[i] = true
+				if i > last {
+					last = i
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, 0, errors.New(fmt.Sprintf("%v has no encoded field %s", t, name))
+		}
+	}
+	return selected, last, nil
+}
@@ -0,0 +1,134 @@
+package bytepack
+
+import (
+	"bytes"
+	"compress/flate"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type projectedCmd struct {
+	Key   string
+	Value []byte
+}
+
+type projectedMsg struct {
+	Ballot   int
+	Payload  []byte
+	Cmds     []projectedCmd
+	Meta     map[string][]string
+	Scores   [4]float64
+	Leader   *person
+	Origin   interface{}
+	Acks     []uint16
+	Flags    [3]bool
+	Slot     uint64
+	Backup   *person
+	Trailing string
+}
+
+func newProjectedMsg() projectedMsg {
+	leader := &person{Name: "Leader", Age: 40, Height: 6.1}
+	return projectedMsg{
+		Ballot:   7,
+		Payload:  bytes.Repeat([]byte("payload"), 1000),
+		Cmds:     []projectedCmd{{Key: "a", Value: []byte("1")}, {Key: "b"}},
+		Meta:     map[string][]string{"x": {"y", "z"}, "empty": nil},
+		Scores:   [4]float64{1, 2, 3, 4},
+		Leader:   leader,
+		Origin:   &person{Name: "Origin"},
+		Acks:     []uint16{1, 2, 3},
+		Flags:    [3]bool{true, false, true},
+		Slot:     42,
+		Backup:   leader,
+		Trailing: "trailing",
+	}
+}
+
+func TestPacker_UnpackFields(t *testing.T) {
+	Register(&person{})
+	s := NewPacker()
+	m := newProjectedMsg()
+	for _, obj := range []interface{}{m, &m} {
+		packed, err := s.Pack(obj)
+		assert.NoError(t, err)
+
+		var m2 projectedMsg
+		err = s.UnpackFields(packed, &m2, "Slot", "Ballot")
+		assert.NoError(t, err)
+		assert.Equal(t, projectedMsg{Ballot: 7, Slot: 42}, m2)
+
+		var m3 projectedMsg
+		err = s.UnpackFields(packed, &m3, "Trailing")
+		assert.NoError(t, err)
+		assert.Equal(t, projectedMsg{Trailing: "trailing"}, m3)
+	}
+}
+
+func TestPacker_UnpackFieldsLeavesOtherFieldsUntouched(t *testing.T) {
+	Register(&person{})
+	s := NewPacker()
+	packed, err := s.Pack(newProjectedMsg())
+	assert.NoError(t, err)
+
+	m := projectedMsg{Ballot: 1, Slot: 1, Trailing: "old"}
+	err = s.UnpackFields(packed, &m, "Slot")
+	assert.NoError(t, err)
+	assert.Equal(t, projectedMsg{Ballot: 1, Slot: 42, Trailing: "old"}, m)
+}
+
+func TestPacker_UnpackFieldsSkippedPointer(t *testing.T) {
+	Register(&person{})
+	s := NewPacker()
+	packed, err := s.Pack(newProjectedMsg())
+	assert.NoError(t, err)
+
+	// Backup points to the same person as Leader, which is skipped
+	var m projectedMsg
+	err = s.UnpackFields(packed, &m, "Backup")
+	assert.NoError(t, err)
+	assert.Nil(t, m.Leader)
+	assert.Equal(t, &person{Name: "Leader", Age: 40, Height: 6.1}, m.Backup)
+
+	var m2 projectedMsg
+	err = s.UnpackFields(packed, &m2, "Leader", "Backup")
+	assert.NoError(t, err)
+	assert.Same(t, m2.Leader, m2.Backup)
+}
+
+func TestPacker_UnpackFieldsEncrypted(t *testing.T) {
+	s := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey))
+	c := newTestCredentials()
+	packed, err := s.Pack(c)
+	assert.NoError(t, err)
+
+	var c2 credentials
+	err = NewPacker().UnpackFields(packed, &c2, "User", "Shared")
+	assert.NoError(t, err)
+	assert.Equal(t, credentials{User: c.User, Shared: c.Shared}, c2)
+}
+
+func TestPacker_UnpackFieldsErrors(t *testing.T) {
+	Register(&person{})
+	s := NewPacker()
+	packed, err := s.Pack(newProjectedMsg())
+	assert.NoError(t, err)
+
+	var m projectedMsg
+	assert.Error(t, s.UnpackFields(packed, &m, "Ballot", "NoSuchField"))
+	assert.Error(t, s.UnpackFields(packed, m, "Ballot"))
+	assert.Error(t, s.UnpackFields(packed, &personS{}, "Name"))
+	assert.Error(t, s.UnpackFields(packed[:len(packed)-20], &m, "Trailing"))
+}
+
+func TestBytePack_UnpackFields(t *testing.T) {
+	Register(&person{})
+	bp := NewBytePack(1, WithCompression(NewFlateCompressor(flate.DefaultCompression), 0), WithCRC32C())
+	packed, err := bp.Pack(newProjectedMsg())
+	assert.NoError(t, err)
+
+	var m projectedMsg
+	err = bp.UnpackFields(packed, &m, "Ballot", "Slot")
+	assert.NoError(t, err)
+	assert.Equal(t, projectedMsg{Ballot: 7, Slot: 42}, m)
+}
@@ -0,0 +1,43 @@
+package bytepack
+
+// Raw
+/*
+   Raw holds a complete packed message, such as one produced by PackRaw. A struct field of type Raw is decoded
+   by capturing the bytes verbatim and encoded by writing them back unchanged, so a relay can forward a nested
+   value without decoding and re-encoding it. Raw is encoded exactly like a []byte, with a length prefix, and its
+   content keeps its own pointer identities. It is not wire-compatible with a field of the nested type itself,
+   so senders, relays and receivers must all declare the field as Raw, or as []byte, and senders fill it with PackRaw.
+   In canonical mode the content is written as is, so pack it canonically if it is hashed or signed.
+*/
+type Raw []byte
+
+// PackRaw packs v into a standalone message that can be carried in a Raw field.
+// It must not be called while the packer is packing another value.
+func (s *Packer) PackRaw(v interface{}) (Raw, error) {
+	data, err := s.Pack(v)
+	if err != nil {
+		return nil, err
+	}
+	return Raw(data), nil
+}
+
+// UnpackRaw decodes the message held in raw into obj
+func (s *Packer) UnpackRaw(raw Raw, obj interface{}) error {
+	return s.Unpack(raw, obj)
+}
+
+// PackRaw packs v into a standalone message that can be carried in a Raw field, like Packer.PackRaw does.
+// The message is never wrapped into an envelope, since the message carrying it is.
+func (p *BytePack) PackRaw(v interface{}) (Raw, error) {
+	// get the packer from the pool
+	s := p.get()
+	raw, err := s.PackRaw(v)
+	// now put the packer back into the pool
+	p.put(s)
+	return raw, err
+}
+
+// UnpackRaw decodes the message held in raw into obj, like Packer.UnpackRaw does
+func (p *BytePack) UnpackRaw(raw Raw, obj interface{}) error {
+	return p.unpackPayload(raw, obj)
+}
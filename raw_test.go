@@ -0,0 +1,106 @@
+package bytepack
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+type relayedCmd struct {
+	Op     string
+	Owner  *person
+	Backup *person
+}
+
+type relayMsg struct {
+	Slot uint64
+	Cmd  Raw
+}
+
+func TestPacker_Raw(t *testing.T) {
+	s := NewPacker()
+	owner := &person{Name: "Tester", Age: 30}
+	cmd := relayedCmd{Op: "put", Owner: owner, Backup: owner}
+
+	raw, err := s.PackRaw(cmd)
+	assert.NoError(t, err)
+	packed, err := s.Pack(relayMsg{Slot: 5, Cmd: raw})
+	assert.NoError(t, err)
+
+	// the relay captures the command verbatim and forwards it unchanged
+	var relayed relayMsg
+	err = s.Unpack(packed, &relayed)
+	assert.NoError(t, err)
+	assert.Equal(t, raw, relayed.Cmd)
+	forwarded, err := s.Pack(&relayed)
+	assert.NoError(t, err)
+
+	var received relayMsg
+	err = s.Unpack(forwarded, &received)
+	assert.NoError(t, err)
+	var cmd2 relayedCmd
+	err = s.UnpackRaw(received.Cmd, &cmd2)
+	assert.NoError(t, err)
+	assert.Equal(t, cmd, cmd2)
+	assert.Same(t, cmd2.Owner, cmd2.Backup)
+}
+
+func TestPacker_RawIsWireCompatibleWithByteSlice(t *testing.T) {
+	type byteMsg struct {
+		Slot uint64
+		Cmd  []byte
+	}
+	s := NewPacker()
+	raw, err := s.PackRaw(person{Name: "Tester"})
+	assert.NoError(t, err)
+
+	packed, err := s.Pack(byteMsg{Slot: 5, Cmd: raw})
+	assert.NoError(t, err)
+	var m relayMsg
+	err = s.Unpack(packed, &m)
+	assert.NoError(t, err)
+	assert.Equal(t, relayMsg{Slot: 5, Cmd: raw}, m)
+
+	packed, err = s.Pack(relayMsg{Slot: 6})
+	assert.NoError(t, err)
+	var m2 relayMsg
+	err = s.Unpack(packed, &m2)
+	assert.NoError(t, err)
+	assert.Nil(t, m2.Cmd)
+	assert.NoError(t, s.Validate(packed, reflect.TypeOf(byteMsg{})))
+}
+
+func TestPacker_RawIsNotWireCompatibleWithTypedField(t *testing.T) {
+	type typedMsg struct {
+		Slot uint64
+		Cmd  relayedCmd
+	}
+	s := NewPacker()
+	typed, err := s.Pack(typedMsg{Slot: 5, Cmd: relayedCmd{Op: "put"}})
+	assert.NoError(t, err)
+	raw, err := s.PackRaw(relayedCmd{Op: "put"})
+	assert.NoError(t, err)
+	wrapped, err := s.Pack(relayMsg{Slot: 5, Cmd: raw})
+	assert.NoError(t, err)
+	// the Raw field carries a length prefix and a message of its own
+	assert.NotEqual(t, typed, wrapped)
+
+	var m typedMsg
+	assert.Error(t, s.Unpack(wrapped, &m))
+}
+
+func TestBytePack_Raw(t *testing.T) {
+	bp := NewBytePack(1, WithCRC32C())
+	raw, err := bp.PackRaw(person{Name: "Tester"})
+	assert.NoError(t, err)
+	packed, err := bp.Pack(relayMsg{Slot: 5, Cmd: raw})
+	assert.NoError(t, err)
+
+	var m relayMsg
+	err = bp.Unpack(packed, &m)
+	assert.NoError(t, err)
+	var p person
+	err = bp.UnpackRaw(m.Cmd, &p)
+	assert.NoError(t, err)
+	assert.Equal(t, "Tester", p.Name)
+}
@@ -0,0 +1,120 @@
+package bytepack
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+   A signed message carries the canonical encoding of a value together with the ID of its signer:
+
+   [payload length uint32][canonical payload][signer id length uint8][signer id][ed25519 signature]
+
+   The signature covers everything before it, so the message can be forwarded and verified by third parties.
+*/
+
+// ErrInvalidSignature is returned when a signed message does not verify against the public key of its signer
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// maxSignerIDLen is the longest signer ID a signed message can carry
+const maxSignerIDLen = 255
+
+// SigningKey is an Ed25519 private key together with the ID receivers know its public key by
+type SigningKey struct {
+	ID         string
+	PrivateKey ed25519.PrivateKey
+}
+
+// PublicKeyLookup returns the public key of the signer with the given ID
+type PublicKeyLookup func(signerID string) (ed25519.PublicKey, error)
+
+// SignedPack
+/*
+   SignedPack packs obj in canonical form and signs it, together with the signer ID, with the given key.
+   Fields tagged for encryption are sealed with the field keys of the Packer as usual, and the signature covers
+   the sealed bytes, so a signed message with such fields differs on every pack.
+*/
+func (s *Packer) SignedPack(obj interface{}, key SigningKey) ([]byte, error) {
+	if len(key.ID) > maxSignerIDLen {
+		return nil, errors.New(fmt.Sprintf("signer ID is longer than %d bytes", maxSignerIDLen))
+	}
+	if len(key.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	canonical, signing := s.canonical, s.signing
+	s.canonical, s.signing = true, true
+	payload, err := s.Pack(obj)
+	s.canonical, s.signing = canonical, signing
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 4, 4+len(payload)+1+len(key.ID)+ed25519.SignatureSize)
+	binary.BigEndian.PutUint32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	out = append(out, uint8(len(key.ID)))
+	out = append(out, key.ID...)
+	return append(out, ed25519.Sign(key.PrivateKey, out)...), nil
+}
+
+// VerifyUnpack
+/*
+   VerifyUnpack checks the signature of a message produced by SignedPack and only then unpacks it into obj.
+   It returns the ID of the signer.
+*/
+func (s *Packer) VerifyUnpack(data []byte, lookup PublicKeyLookup, obj interface{}) (string, error) {
+	return s.VerifyUnpackFromReader(bytes.NewReader(data), lookup, obj)
+}
+
+// VerifyUnpackFromReader reads one signed message from buf, checks its signature, and unpacks it into obj
+func (s *Packer) VerifyUnpackFromReader(buf BPReader, lookup PublicKeyLookup, obj interface{}) (string, error) {
+	var signed bytes.Buffer
+	_, err := io.CopyN(&signed, buf, 4)
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	payloadLen := binary.BigEndian.Uint32(signed.Bytes())
+	// copy instead of allocating the payload upfront, so a corrupted length cannot make us allocate a huge buffer
+	_, err = io.CopyN(&signed, buf, int64(payloadLen)+1)
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	idLen := signed.Bytes()[signed.Len()-1]
+	_, err = io.CopyN(&signed, buf, int64(idLen))
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	signature := make([]byte, ed25519.SignatureSize)
+	_, err = io.ReadFull(buf, signature)
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+
+	message := signed.Bytes()
+	signerID := string(message[len(message)-int(idLen):])
+	pubKey, err := lookup(signerID)
+	if err != nil {
+		return signerID, err
+	}
+	if len(pubKey) != ed25519.PublicKeySize || !ed25519.Verify(pubKey, message, signature) {
+		return signerID, ErrInvalidSignature
+	}
+
+	canonical := s.canonical
+	s.canonical = true
+	defer func() {
+		s.canonical = canonical
+	}()
+	return signerID, s.Unpack(message[4:4+payloadLen], obj)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
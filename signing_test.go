@@ -0,0 +1,145 @@
+package bytepack
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+type signedVote struct {
+	Ballot int
+	Slot   int64
+	Acks   map[string]bool
+}
+
+func newTestSigners(t *testing.T, ids ...string) (map[string]SigningKey, PublicKeyLookup) {
+	rnd := rand.New(rand.NewSource(42))
+	signers := make(map[string]SigningKey)
+	pubKeys := make(map[string]ed25519.PublicKey)
+	for _, id := range ids {
+		pub, priv, err := ed25519.GenerateKey(rnd)
+		assert.NoError(t, err)
+		signers[id] = SigningKey{ID: id, PrivateKey: priv}
+		pubKeys[id] = pub
+	}
+	lookup := func(signerID string) (ed25519.PublicKey, error) {
+		pub, exists := pubKeys[signerID]
+		if !exists {
+			return nil, errors.New("unknown signer " + signerID)
+		}
+		return pub, nil
+	}
+	return signers, lookup
+}
+
+func TestPacker_SignedPack(t *testing.T) {
+	signers, lookup := newTestSigners(t, "replica1", "replica2")
+	v := signedVote{Ballot: 3, Slot: 17, Acks: map[string]bool{"replica1": true, "replica2": false, "replica3": true}}
+
+	s := NewPacker()
+	signed, err := s.SignedPack(v, signers["replica1"])
+	assert.NoError(t, err)
+
+	// a third party verifies the forwarded message
+	var v2 signedVote
+	signerID, err := NewPacker().VerifyUnpack(signed, lookup, &v2)
+	assert.NoError(t, err)
+	assert.Equal(t, "replica1", signerID)
+	assert.Equal(t, v, v2)
+
+	// the payload is the canonical encoding of the value
+	canonical, err := NewPacker(WithCanonical()).Pack(v)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Contains(signed, canonical))
+	assert.False(t, s.canonical)
+}
+
+func TestPacker_SignedPackEncryptedFields(t *testing.T) {
+	signers, lookup := newTestSigners(t, "replica1")
+	keys := newTestKeyRing(t, 1)
+	c := newTestCredentials()
+
+	s := NewPacker(WithFieldEncryption(keys, ErrorOnMissingKey))
+	signed, err := s.SignedPack(c, signers["replica1"])
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(signed, []byte("hunter2")))
+	assert.False(t, s.signing)
+
+	var c2 credentials
+	_, err = NewPacker(WithFieldEncryption(keys, ErrorOnMissingKey)).VerifyUnpack(signed, lookup, &c2)
+	assert.NoError(t, err)
+	assert.Equal(t, c, c2)
+
+	// a verifier without the field keys still checks the signature
+	var c3 credentials
+	_, err = NewPacker(WithFieldEncryption(nil, ZeroOnMissingKey)).VerifyUnpack(signed, lookup, &c3)
+	assert.NoError(t, err)
+	assert.Equal(t, "tester", c3.User)
+	assert.Empty(t, c3.Password)
+
+	// the canonical encoding itself still rejects them
+	_, err = NewPacker(WithCanonical(), WithFieldEncryption(keys, ErrorOnMissingKey)).Pack(c)
+	assert.ErrorContains(t, err, "no canonical encoding")
+}
+
+func TestPacker_VerifyUnpackRejectsForgeries(t *testing.T) {
+	signers, lookup := newTestSigners(t, "replica1", "replica2")
+	v := signedVote{Ballot: 3, Slot: 17}
+	s := NewPacker()
+	signed, err := s.SignedPack(v, signers["replica1"])
+	assert.NoError(t, err)
+
+	for i := 0; i < len(signed); i++ {
+		tampered := make([]byte, len(signed))
+		copy(tampered, signed)
+		tampered[i] ^= 0x04
+		var v2 signedVote
+		_, err = s.VerifyUnpack(tampered, lookup, &v2)
+		assert.Error(t, err)
+	}
+
+	// replica2 cannot claim a message signed by replica1
+	claimed := make([]byte, len(signed))
+	copy(claimed, signed)
+	idStart := len(signed) - ed25519.SignatureSize - len("replica1")
+	copy(claimed[idStart:], "replica2")
+	var v3 signedVote
+	signerID, err := s.VerifyUnpack(claimed, lookup, &v3)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	assert.Equal(t, "replica2", signerID)
+	assert.Equal(t, signedVote{}, v3)
+}
+
+func TestBytePack_SignedPackFromReader(t *testing.T) {
+	signers, lookup := newTestSigners(t, "replica1", "replica2")
+	bps := []*BytePack{
+		NewBytePack(2),
+		NewBytePack(2, WithCRC32C(), WithEncryption(newTestKeyRing(t, 1))),
+	}
+	for _, bp := range bps {
+		v1 := signedVote{Ballot: 1, Slot: 1}
+		v2 := signedVote{Ballot: 2, Slot: 2, Acks: map[string]bool{"replica1": true}}
+		signed1, err := bp.SignedPack(v1, signers["replica1"])
+		assert.NoError(t, err)
+		signed2, err := bp.SignedPack(v2, signers["replica2"])
+		assert.NoError(t, err)
+
+		stream := bytes.NewBuffer(append(signed1, signed2...))
+		var decoded signedVote
+		signerID, err := bp.VerifyUnpackFromReader(stream, lookup, &decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, "replica1", signerID)
+		assert.Equal(t, v1, decoded)
+		signerID, err = bp.VerifyUnpackFromReader(stream, lookup, &decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, "replica2", signerID)
+		assert.Equal(t, v2, decoded)
+
+		signerID, err = bp.VerifyUnpack(signed2, lookup, &decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, "replica2", signerID)
+	}
+}
@@ -0,0 +1,308 @@
+package bytepack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Size
+/*
+   Size returns the number of bytes Pack would produce for obj, without encoding it. Structs implementing Packable
+   are the exception, since their encoding is only known once they pack themselves.
+*/
+func (s *Packer) Size(obj interface{}) (int, error) {
+	s.resetEncoder()
+	return s.sizeRoot(obj)
+}
+
+// sizeRoot mirrors encode
+func (s *Packer) sizeRoot(obj interface{}) (int, error) {
+	switch obj.(type) {
+	case Packable:
+		data, err := s.encodeScoped(func() error {
+			return obj.(Packable).Pack(s)
+		})
+		return len(data), err
+	case nil:
+		return 0, errors.New("cannot encode nil")
+	}
+
+	t := reflect.TypeOf(obj)
+	switch t.Kind() {
+	case reflect.Struct:
+		v := reflect.ValueOf(obj)
+		if s.canonical || reflect.PtrTo(t).Implements(packableType) {
+			p := reflect.New(t)
+			p.Elem().Set(v)
+			return s.sizeRoot(p.Interface())
+		}
+		if s.ptrIdCounter == 1 {
+			s.ptrIdCounter = 2
+		}
+		err := s.checkType(t)
+		if err != nil {
+			return 0, err
+		}
+		size, err := s.sizeValue(v)
+		return 1 + size, err
+	case reflect.Ptr:
+		v := reflect.ValueOf(obj)
+		if v.IsNil() {
+			return 0, errors.New("cannot encode nil struct")
+		}
+		if v.Elem().Kind() == reflect.Struct {
+			err := s.checkType(t)
+			if err != nil {
+				return 0, err
+			}
+			size, err := s.sizeValue(v)
+			return 1 + size, err
+		} else if v.Elem().Kind() == reflect.Interface {
+			return s.sizeRoot(v.Elem().Interface())
+		}
+		return 0, errors.New(fmt.Sprintf("cannot encode poiners to non-struct. Got: %v", v.Elem().Kind()))
+	case reflect.Chan:
+		return 0, unsupportedKindError(t)
+	default:
+		err := s.checkType(t)
+		if err != nil {
+			return 0, err
+		}
+		return s.sizeValue(reflect.ValueOf(obj))
+	}
+}
+
+// sizeValue mirrors encodeValue
+func (s *Packer) sizeValue(val reflect.Value) (int, error) {
+	base := len(s.frames)
+	size, err := s.sizeItem(val)
+	if err != nil || len(s.frames) == base {
+		return size, err
+	}
+	rest, err := s.sizeFrames(base)
+	return size + rest, err
+}
+
+// sizeFrames mirrors encodeFrames
+func (s *Packer) sizeFrames(base int) (int, error) {
+	if !s.beginWalk() {
+		var size int
+		err := s.onFreshStack(func() error {
+			var err error
+			size, err = s.sizeFrames(base)
+			return err
+		})
+		return size, err
+	}
+	defer s.endWalk(base)
+	size := 0
+	for len(s.frames) > base {
+		f := s.top()
+		var itemSize int
+		var err error
+		switch f.kind {
+		case structFrame:
+			if f.i == len(f.fields) {
+				s.pop()
+				continue
+			}
+			fi := f.fields[f.i]
+			f.i++
+			itemSize, err = s.sizeField(s.field(f.v, fi), fi)
+		case elemsFrame:
+			if f.i == f.n {
+				s.pop()
+				continue
+			}
+			elem := f.v.Index(f.i)
+			f.i++
+			if f.fields != nil {
+				err = s.pushStruct(elem, f.fields)
+			} else {
+				itemSize, err = s.sizeItem(elem)
+			}
+		case mapFrame:
+			itemSize, err = s.sizeEntry(f)
+		case valueFrame:
+			if f.i == 1 {
+				s.pop()
+				continue
+			}
+			f.i = 1
+			itemSize, err = s.sizeItem(f.elem)
+		}
+		if err != nil {
+			return 0, err
+		}
+		size += itemSize
+	}
+	return size, nil
+}
+
+// sizeField mirrors encodeField
+func (s *Packer) sizeField(f reflect.Value, fi fieldInfo) (int, error) {
+	if fi.encrypt {
+		return s.sizeEncryptedField(f, fi)
+	}
+	if fi.lazy {
+		return s.sizeLazy(f)
+	}
+	return s.sizeItem(f)
+}
+
+func (s *Packer) sizeLazy(f reflect.Value) (int, error) {
+	payloadSize, err := f.Interface().(lazyField).lazySize(s)
+	if err != nil || payloadSize < 0 {
+		return 1, err
+	}
+	return 1 + 4 + payloadSize, nil
+}
+
+func (s *Packer) sizeEncryptedField(f reflect.Value, fi fieldInfo) (int, error) {
+	if s.canonical {
+		return 0, errors.New("encrypted fields have no canonical encoding")
+	}
+	if s.fieldKeys == nil {
+		return 0, errors.New(fmt.Sprintf("field %s is tagged for encryption, but the packer has no field keys", fi.aad))
+	}
+	_, aead, err := s.fieldKeys.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+	ptrIdCounter, ptrstoid := s.ptrIdCounter, s.ptrstoid
+	s.ptrIdCounter, s.ptrstoid = 1, make(map[uintptr]uint32, 0)
+	var plainSize int
+	if fi.lazy {
+		plainSize, err = s.sizeLazy(f)
+	} else {
+		plainSize, err = s.sizeValue(f)
+	}
+	s.ptrIdCounter, s.ptrstoid = ptrIdCounter, ptrstoid
+	if err != nil {
+		return 0, err
+	}
+	// key id, sealed length, and the sealed field
+	return 4 + 4 + aead.NonceSize() + plainSize + aead.Overhead(), nil
+}
+
+// sizeItem mirrors encodeItem
+func (s *Packer) sizeItem(val reflect.Value) (int, error) {
+	if size := s.wireSize(val.Kind()); size > 0 {
+		return size, nil
+	}
+	switch val.Kind() {
+	case reflect.String:
+		return 4 + val.Len(), nil
+	case reflect.Struct:
+		return 0, s.pushStruct(val, s.structFields(val.Type()))
+	case reflect.Slice:
+		if val.IsNil() {
+			return 1, nil
+		}
+		size, err := s.sizeElements(val)
+		if numChunks := s.chunkCount(val); numChunks > 0 {
+			return chunkHeaderSize(numChunks) + size, err
+		}
+		return 1 + 4 + size, err
+	case reflect.Array:
+		return s.sizeElements(val)
+	case reflect.Map:
+		return s.sizeMap(val)
+	case reflect.Ptr:
+		return s.sizePointer(val)
+	case reflect.Interface:
+		if val.IsNil() {
+			return 1, nil
+		}
+		elem := val.Elem()
+		err := s.checkInterfaceValue(elem)
+		if err != nil {
+			return 0, err
+		}
+		dataType := elem.Type()
+		if dataType.Kind() == reflect.Ptr {
+			dataType = dataType.Elem()
+			elem = elem.Elem()
+		}
+		// not nil flag, pointer flag, and the type name
+		return 1 + 1 + 4 + len(dataType.PkgPath()+dataType.Name()), s.push(frame{kind: valueFrame, elem: elem})
+	case reflect.Chan:
+		return 0, nil
+	default:
+		return 0, unsupportedKindError(val.Type())
+	}
+}
+
+// sizeElements returns the size of the elements of slice or array val that are written in bulk,
+// or pushes a frame for the others
+func (s *Packer) sizeElements(val reflect.Value) (int, error) {
+	elemKind := val.Type().Elem().Kind()
+	if !s.bulkKind(elemKind) {
+		return 0, s.push(frame{kind: elemsFrame, v: val, n: val.Len(), fields: s.elemFields(val.Type().Elem())})
+	}
+	err := s.reach()
+	if err != nil {
+		return 0, err
+	}
+	if elemSize := s.wireSize(elemKind); elemSize > 0 {
+		return elemSize * val.Len(), nil
+	}
+	size := 0
+	for i := 0; i < val.Len(); i++ {
+		size += 4 + val.Index(i).Len()
+	}
+	return size, nil
+}
+
+func (s *Packer) sizeMap(m reflect.Value) (int, error) {
+	if m.IsNil() {
+		return 1, nil
+	}
+	keyType := m.Type().Key()
+	if s.canonical && typeMayHavePointers(keyType, make(map[reflect.Type]bool)) {
+		return 0, errors.New(fmt.Sprintf("map keys of type %v have no canonical encoding", keyType))
+	}
+	size := 1 + 4
+	if numChunks := s.chunkCount(m); numChunks > 0 {
+		size = chunkHeaderSize(numChunks)
+	}
+	entries := s.newMapEntries()
+	entries.iter.Reset(m)
+	entries.key = reflect.New(keyType).Elem()
+	entries.value = reflect.New(m.Type().Elem()).Elem()
+	return size, s.push(frame{kind: mapFrame, v: m, n: m.Len(), entries: entries})
+}
+
+// sizeEntry mirrors encodeEntry, in the order of the map
+func (s *Packer) sizeEntry(f *frame) (int, error) {
+	entries := f.entries
+	if entries.step == valueNext {
+		entries.step = keyNext
+		entries.value.SetIterValue(&entries.iter)
+		return s.sizeItem(entries.value)
+	}
+	if !entries.iter.Next() {
+		s.pop()
+		return 0, nil
+	}
+	entries.step = valueNext
+	entries.key.SetIterKey(&entries.iter)
+	return s.sizeItem(entries.key)
+}
+
+// sizePointer mirrors encodePointer
+func (s *Packer) sizePointer(ptr reflect.Value) (int, error) {
+	if ptr.IsNil() {
+		return 2, nil
+	}
+	if ptrId, exists := s.ptrstoid[ptr.Pointer()]; exists {
+		return pointerIDSize(ptrId), nil
+	}
+	ptrId, err := s.nextPointerID()
+	if err != nil {
+		return 0, err
+	}
+	s.ptrstoid[ptr.Pointer()] = ptrId
+	return pointerIDSize(ptrId), s.push(frame{kind: valueFrame, elem: ptr.Elem()})
+}
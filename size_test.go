@@ -0,0 +1,71 @@
+package bytepack
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func assertSizeMatchesPack(t *testing.T, s *Packer, v interface{}) {
+	size, err := s.Size(v)
+	assert.NoError(t, err)
+	packed, err := s.Pack(v)
+	assert.NoError(t, err)
+	assert.Equal(t, len(packed), size, "%T", v)
+}
+
+func TestPacker_Size(t *testing.T) {
+	Register(&person{})
+	m := newProjectedMsg()
+	owner := &person{Name: "Owner"}
+	values := []interface{}{
+		person{Name: "Tester", Age: 30, Height: 5.25},
+		&person{Name: "Tester"},
+		m,
+		&m,
+		newSnapshotMsg(20),
+		person3{Name: "Parent", Children: []person{{Name: "Child"}}, LuckyNumbers: []int{1, 2}},
+		mergedState{Owner: owner, Last: owner, Entries: map[string]int32{"a": 1}, Window: [4]int16{1}},
+		heartbeat{Term: 1, Snapshot: NewLazy(newSnapshotMsg(5))},
+		&personS{Name: "Packable"},
+		[]string{"a", "bc"},
+		map[int32][]uint64{1: {2, 3}, 4: nil},
+		complex64(1 + 2i),
+		"string",
+	}
+	for _, opts := range [][]PackerOption{nil, {WithCanonical()}, {WithUnexportedFields(IncludeUnexported)}} {
+		s := NewPacker(opts...)
+		for _, v := range values {
+			assertSizeMatchesPack(t, s, v)
+		}
+	}
+}
+
+func TestPacker_SizeOfCapturedValues(t *testing.T) {
+	s := NewPacker()
+	packed, err := s.Pack(heartbeat{Term: 1, Snapshot: NewLazy(newSnapshotMsg(5)), Leader: NewLazy(&person{Name: "Leader"})})
+	assert.NoError(t, err)
+	var hb heartbeat
+	assert.NoError(t, s.Unpack(packed, &hb))
+	assertSizeMatchesPack(t, s, hb)
+	assertSizeMatchesPack(t, NewPacker(WithCanonical()), hb)
+}
+
+func TestPacker_SizeWithEncryptedFields(t *testing.T) {
+	s := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey))
+	assertSizeMatchesPack(t, s, newTestCredentials())
+
+	_, err := NewPacker().Size(newTestCredentials())
+	assert.Error(t, err)
+}
+
+func TestPacker_SizeErrors(t *testing.T) {
+	s := NewPacker()
+	_, err := s.Size(nil)
+	assert.Error(t, err)
+	_, err = s.Size(func() {})
+	assert.ErrorIs(t, err, ErrUnsupportedKind)
+	_, err = s.Size(struct{ I interface{} }{I: 5})
+	assert.Error(t, err)
+	_, err = NewPacker(WithCanonical()).Size(map[*person]int{{}: 1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,377 @@
+package bytepack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Skip
+/*
+   Skip reads past one message packed from a value of type t, without decoding it.
+   t is the type of the value Unpack would decode the message into.
+*/
+func (s *Packer) Skip(buf BPReader, t reflect.Type) error {
+	s.idstoptr = make(map[uint32]*decodingPtr, 0)
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(packableType) {
+		return errors.New(fmt.Sprintf("cannot skip %v, it packs itself", t))
+	}
+	err := s.checkType(t)
+	if err != nil {
+		return err
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		notNil, err := s.readRootHeader(buf, reflect.Value{})
+		if err != nil || !notNil {
+			return err
+		}
+		return s.skipValue(t, buf)
+	case reflect.Interface, reflect.Ptr, reflect.Chan:
+		return fmt.Errorf("cannot skip this type")
+	default:
+		return s.skipValue(t, buf)
+	}
+}
+
+// Validate
+/*
+   Validate checks that data holds exactly one well-formed message packed from a value of type t, without decoding it.
+   Encrypted fields are only checked for their framing, since their content cannot be read without the key.
+*/
+func (s *Packer) Validate(data []byte, t reflect.Type) error {
+	buf := newSliceReader(data)
+	err := s.Skip(buf, t)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+	if buf.Len() > 0 {
+		return errors.New(fmt.Sprintf("%d unexpected bytes after the end of the message", buf.Len()))
+	}
+	return nil
+}
+
+// readRootHeader reads the flag in front of a packed struct and, if the struct was packed through a pointer,
+// the root pointer header. It returns false if the root pointer was nil.
+func (s *Packer) readRootHeader(buf BPReader, root reflect.Value) (bool, error) {
+	flag, err := s.UnpackUint8(buf)
+	if err != nil {
+		return false, err
+	}
+	switch flag {
+	case 0:
+		return true, nil
+	case 1:
+		ptrId, isNil, err := s.unpackPointerHeader(buf)
+		if err != nil {
+			return false, err
+		}
+		if isNil {
+			return false, nil
+		}
+		if ptrId != 1 {
+			return false, errors.New("invalid root pointer")
+		}
+		s.idstoptr[1] = &decodingPtr{
+			isDecoded: true,
+			ptr:       root,
+		}
+		return true, nil
+	default:
+		return false, errors.New(fmt.Sprintf("invalid root flag %d", flag))
+	}
+}
+
+// wireSize returns the encoded size of a value of kind k, or 0 if values of the kind vary in size
+func (s *Packer) wireSize(k reflect.Kind) int {
+	switch k {
+	case reflect.Bool, reflect.Int8, reflect.Uint8:
+		return 1
+	case reflect.Int16, reflect.Uint16:
+		return 2
+	case reflect.Int32, reflect.Uint32, reflect.Float32:
+		return 4
+	case reflect.Int64, reflect.Uint64, reflect.Float64, reflect.Complex64, reflect.Uintptr:
+		return 8
+	case reflect.Complex128:
+		return 16
+	case reflect.Int, reflect.Uint:
+		return s.intWidth()
+	}
+	return 0
+}
+
+// skipValue reads past an encoded value of type t without decoding it.
+// Pointers whose first occurrence is skipped are remembered, so later references to them can still be decoded.
+func (s *Packer) skipValue(t reflect.Type, buf BPReader) error {
+	base := len(s.frames)
+	err := s.skipItem(t, buf)
+	if err != nil || len(s.frames) == base {
+		return err
+	}
+	return s.skipFrames(buf, base)
+}
+
+// skipFrames reads past the values of the frames above base. Frames of skipped values hold types instead of values.
+func (s *Packer) skipFrames(buf BPReader, base int) error {
+	if !s.beginWalk() {
+		return s.onFreshStack(func() error {
+			return s.skipFrames(buf, base)
+		})
+	}
+	defer s.endWalk(base)
+	for len(s.frames) > base {
+		f := s.top()
+		var err error
+		switch f.kind {
+		case structFrame:
+			if f.i == len(f.fields) {
+				s.pop()
+				continue
+			}
+			fi := f.fields[f.i]
+			f.i++
+			if fi.encrypt {
+				err = s.skipEncryptedField(buf)
+			} else {
+				err = s.skipItem(fieldType(f.t, fi), buf)
+			}
+		case elemsFrame:
+			if f.i == f.n {
+				s.pop()
+				continue
+			}
+			f.i++
+			if f.fields != nil {
+				err = s.push(frame{kind: structFrame, t: f.t, fields: f.fields})
+			} else {
+				err = s.skipItem(f.t, buf)
+			}
+		case mapFrame:
+			// keys and values take turns
+			if f.i == f.n {
+				s.pop()
+				continue
+			}
+			t := f.t.Key()
+			if f.i%2 == 1 {
+				t = f.t.Elem()
+			}
+			f.i++
+			err = s.skipItem(t, buf)
+		case valueFrame:
+			if f.i == 1 {
+				s.pop()
+				continue
+			}
+			f.i = 1
+			err = s.skipItem(f.t, buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipItem reads past a value of type t, or if the value holds other values, reads past its header and
+// pushes a frame for them
+func (s *Packer) skipItem(t reflect.Type, buf BPReader) error {
+	if size := s.wireSize(t.Kind()); size > 0 {
+		return skipBytes(buf, int64(size))
+	}
+	switch t.Kind() {
+	case reflect.String:
+		strLen, err := s.UnpackInt32(buf)
+		if err != nil {
+			return err
+		}
+		if strLen < 0 {
+			return errors.New(fmt.Sprintf("invalid string length %d", strLen))
+		}
+		return skipBytes(buf, int64(strLen))
+	case reflect.Struct:
+		return s.push(frame{kind: structFrame, t: t, fields: s.structFields(t)})
+	case reflect.Array:
+		return s.skipElements(t.Elem(), t.Len(), buf)
+	case reflect.Slice:
+		numEntries, isNil, chunks, err := s.readCollectionHeader(buf, t)
+		if err != nil || isNil {
+			return err
+		}
+		if chunks != nil {
+			return s.skipChunked(buf, chunks, func() error {
+				return s.skipValue(t.Elem(), buf)
+			})
+		}
+		return s.skipElements(t.Elem(), numEntries, buf)
+	case reflect.Map:
+		numEntries, isNil, chunks, err := s.readCollectionHeader(buf, t)
+		if err != nil || isNil {
+			return err
+		}
+		if chunks != nil {
+			return s.skipChunked(buf, chunks, func() error {
+				err := s.skipValue(t.Key(), buf)
+				if err != nil {
+					return err
+				}
+				return s.skipValue(t.Elem(), buf)
+			})
+		}
+		return s.push(frame{kind: mapFrame, t: t, n: 2 * numEntries})
+	case reflect.Ptr:
+		return s.skipPointer(t, buf)
+	case reflect.Interface:
+		return s.skipInterface(buf)
+	case reflect.Chan:
+		// channels are never encoded
+		return nil
+	default:
+		return unsupportedKindError(t)
+	}
+}
+
+// skipField reads past a single field of struct type t
+func (s *Packer) skipField(t reflect.Type, fi fieldInfo, buf BPReader) error {
+	if fi.encrypt {
+		return s.skipEncryptedField(buf)
+	}
+	return s.skipValue(fieldType(t, fi), buf)
+}
+
+// fieldType returns the type a field of struct type t is encoded as
+func fieldType(t reflect.Type, fi fieldInfo) reflect.Type {
+	if fi.lazy {
+		return bytesType
+	}
+	return t.Field(fi.index).Type
+}
+
+func (s *Packer) skipElements(elemType reflect.Type, numEntries int, buf BPReader) error {
+	if !s.bulkKind(elemType.Kind()) {
+		return s.push(frame{kind: elemsFrame, t: elemType, n: numEntries, fields: s.elemFields(elemType)})
+	}
+	err := s.reach()
+	if err != nil {
+		return err
+	}
+	if size := s.wireSize(elemType.Kind()); size > 0 {
+		return skipBytes(buf, int64(size)*int64(numEntries))
+	}
+	for i := 0; i < numEntries; i++ {
+		err = s.skipItem(elemType, buf)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Packer) skipPointer(ptrType reflect.Type, buf BPReader) error {
+	ptrId, isNil, err := s.unpackPointerHeader(buf)
+	if err != nil || isNil {
+		return err
+	}
+	if s.idstoptr[ptrId] != nil {
+		// the value was written with the first reference to the pointer
+		return nil
+	}
+	s.idstoptr[ptrId] = &decodingPtr{
+		isDecoded: false,
+		skipped:   remaining(buf),
+	}
+	return s.push(frame{kind: valueFrame, t: ptrType.Elem()})
+}
+
+func (s *Packer) skipInterface(buf BPReader) error {
+	notNil, err := s.UnpackBool(buf)
+	if err != nil || !notNil {
+		return err
+	}
+	// the pointer flag does not change how the value is encoded
+	_, err = s.UnpackBool(buf)
+	if err != nil {
+		return err
+	}
+	typeStr, err := s.UnpackString(buf)
+	if err != nil {
+		return err
+	}
+	ifaceType, exists := registeredType(typeStr)
+	if !exists {
+		return unregisteredTypeError(typeStr)
+	}
+	err = s.checkType(ifaceType)
+	if err != nil {
+		return err
+	}
+	return s.push(frame{kind: valueFrame, t: ifaceType})
+}
+
+func (s *Packer) skipEncryptedField(buf BPReader) error {
+	// key id
+	err := skipBytes(buf, 4)
+	if err != nil {
+		return err
+	}
+	sealedLen, err := s.UnpackInt32(buf)
+	if err != nil {
+		return err
+	}
+	if sealedLen < 0 {
+		return errors.New(fmt.Sprintf("invalid length %d of encrypted field", sealedLen))
+	}
+	return skipBytes(buf, int64(sealedLen))
+}
+
+// readSkippedPointer decodes the value of a pointer whose first occurrence was skipped
+func (s *Packer) readSkippedPointer(ptrType reflect.Type, ptrId uint32) (reflect.Value, error) {
+	dp := s.idstoptr[ptrId]
+	data := dp.skipped
+	// the pointer is known before its value is decoded, so references to it from within the value resolve to it
+	dp.skipped = nil
+	dp.ptr = reflect.New(ptrType.Elem())
+	dp.isDecoded = true
+	err := s.readValue(newSliceReader(data), dp.ptr.Elem())
+	if err != nil {
+		return reflect.New(ptrType).Elem(), err
+	}
+	return dp.ptr, nil
+}
+
+// skipBytes discards the next n bytes of buf, without copying them when buf is in memory
+func skipBytes(buf BPReader, n int64) error {
+	switch r := buf.(type) {
+	case *sliceReader:
+		_, err := r.next(int(n))
+		return unexpectedEOF(err)
+	case *bytes.Buffer:
+		if int64(r.Len()) < n {
+			r.Reset()
+			return io.ErrUnexpectedEOF
+		}
+		r.Next(int(n))
+		return nil
+	case interface {
+		io.Seeker
+		Len() int
+	}:
+		if int64(r.Len()) < n {
+			_, err := r.Seek(0, io.SeekEnd)
+			if err != nil {
+				return err
+			}
+			return io.ErrUnexpectedEOF
+		}
+		_, err := r.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, buf, n)
+	return unexpectedEOF(err)
+}
@@ -0,0 +1,94 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+func TestPacker_Skip(t *testing.T) {
+	Register(&person{})
+	s := NewPacker()
+	var stream bytes.Buffer
+	m := newProjectedMsg()
+	for _, obj := range []interface{}{m, &m, []string{"a", "b"}, map[int32]string{1: "a"}, person{Name: "Last"}} {
+		packed, err := s.Pack(obj)
+		assert.NoError(t, err)
+		stream.Write(packed)
+	}
+
+	assert.NoError(t, s.Skip(&stream, reflect.TypeOf(projectedMsg{})))
+	assert.NoError(t, s.Skip(&stream, reflect.TypeOf(&projectedMsg{})))
+	assert.NoError(t, s.Skip(&stream, reflect.TypeOf([]string{})))
+	assert.NoError(t, s.Skip(&stream, reflect.TypeOf(map[int32]string{})))
+
+	var p person
+	err := s.UnpackFromReader(&stream, &p)
+	assert.NoError(t, err)
+	assert.Equal(t, "Last", p.Name)
+	assert.Equal(t, 0, stream.Len())
+}
+
+func TestPacker_SkipEncryptedFields(t *testing.T) {
+	packed, err := NewPacker(WithFieldEncryption(newTestKeyRing(t, 1), ErrorOnMissingKey)).Pack(newTestCredentials())
+	assert.NoError(t, err)
+	assert.NoError(t, NewPacker().Validate(packed, reflect.TypeOf(credentials{})))
+}
+
+func TestPacker_Validate(t *testing.T) {
+	Register(&person{})
+	s := NewPacker()
+	packed, err := s.Pack(newProjectedMsg())
+	assert.NoError(t, err)
+	msgType := reflect.TypeOf(projectedMsg{})
+
+	assert.NoError(t, s.Validate(packed, msgType))
+	for i := 0; i < len(packed); i++ {
+		assert.Error(t, s.Validate(packed[:i], msgType))
+	}
+	assert.Error(t, s.Validate(append(packed, 0), msgType))
+	assert.Error(t, s.Validate(packed, reflect.TypeOf(person{})))
+
+	// a negative length of the payload
+	bad := make([]byte, len(packed))
+	copy(bad, packed)
+	bad[10] = 0xFF
+	assert.Error(t, s.Validate(bad, msgType))
+}
+
+func TestPacker_ValidateErrors(t *testing.T) {
+	s := NewPacker()
+	packed, err := s.Pack(person{Name: "Tester"})
+	assert.NoError(t, err)
+
+	assert.Error(t, s.Validate(packed, reflect.TypeOf(&personS{})))
+	assert.Error(t, s.Validate(packed, reflect.TypeOf(func() {})))
+
+	// the root flag is either 0 or 1
+	packed[0] = 2
+	assert.Error(t, s.Validate(packed, reflect.TypeOf(person{})))
+}
+
+func TestBytePack_Validate(t *testing.T) {
+	bp := NewBytePack(1, WithCRC32C())
+	packed, err := bp.Pack(person{Name: "Tester"})
+	assert.NoError(t, err)
+	assert.NoError(t, bp.Validate(packed, reflect.TypeOf(person{})))
+
+	packed[len(packed)-1] ^= 1
+	assert.ErrorIs(t, bp.Validate(packed, reflect.TypeOf(person{})), ErrChecksumMismatch)
+
+	var stream bytes.Buffer
+	packed, err = bp.Pack(person{Name: "First"})
+	assert.NoError(t, err)
+	stream.Write(packed)
+	packed, err = bp.Pack(person{Name: "Second"})
+	assert.NoError(t, err)
+	stream.Write(packed)
+
+	assert.NoError(t, bp.Skip(&stream, reflect.TypeOf(person{})))
+	var p person
+	assert.NoError(t, bp.UnpackFromReader(&stream, &p))
+	assert.Equal(t, "Second", p.Name)
+}
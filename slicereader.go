@@ -0,0 +1,77 @@
+package bytepack
+
+import (
+	"io"
+)
+
+// sliceReader
+/*
+   sliceReader decodes a message held in memory. Fixed-width values are read straight out of the slice by index,
+   with a single bounds check, instead of through one interface call per byte.
+   It also lets skipping and projection find the unread rest of the message without copying it.
+*/
+type sliceReader struct {
+	data []byte
+	pos  int
+}
+
+func newSliceReader(data []byte) *sliceReader {
+	return &sliceReader{data: data}
+}
+
+// next returns the next n bytes and advances past them. The bytes alias the message and must be copied if kept.
+// Like io.ReadFull, it returns io.EOF at the end of the message and io.ErrUnexpectedEOF when fewer than n bytes are left.
+func (r *sliceReader) next(n int) ([]byte, error) {
+	if n > len(r.data)-r.pos {
+		if r.pos == len(r.data) && n > 0 {
+			return nil, io.EOF
+		}
+		r.pos = len(r.data)
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if r.pos == len(r.data) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+func (r *sliceReader) ReadByte() (byte, error) {
+	if r.pos == len(r.data) {
+		return 0, io.EOF
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+// Len returns the number of unread bytes
+func (r *sliceReader) Len() int {
+	return len(r.data) - r.pos
+}
+
+// remaining returns the unread part of the message, or nil if buf does not read a message held in memory
+func remaining(buf BPReader) []byte {
+	if r, ok := buf.(*sliceReader); ok {
+		return r.data[r.pos:]
+	}
+	return nil
+}
+
+// readByte reads a single byte, without an interface call when buf is in memory
+func readByte(buf BPReader) (byte, error) {
+	if r, ok := buf.(*sliceReader); ok {
+		return r.ReadByte()
+	}
+	return buf.ReadByte()
+}
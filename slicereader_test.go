@@ -0,0 +1,54 @@
+package bytepack
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestPacker_UnpackFromSliceMatchesStream(t *testing.T) {
+	s := NewPacker()
+	m := newSnapshotMsg(50)
+	buf, err := s.Pack(&m)
+	assert.NoError(t, err)
+
+	var fromSlice, fromStream snapshotMsg
+	assert.NoError(t, s.Unpack(buf, &fromSlice))
+	assert.NoError(t, s.UnpackFromReader(bytes.NewBuffer(buf), &fromStream))
+	assert.Equal(t, m, fromSlice)
+	assert.Equal(t, fromStream, fromSlice)
+
+	// decoded values never alias the input
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	assert.Equal(t, m, fromSlice)
+}
+
+func TestPacker_UnpackFromSliceTruncated(t *testing.T) {
+	s := NewPacker()
+	buf, err := s.Pack(newSnapshotMsg(5))
+	assert.NoError(t, err)
+
+	for n := 0; n < len(buf); n++ {
+		var m snapshotMsg
+		assert.Error(t, s.Unpack(buf[:n], &m), "truncated to %d bytes", n)
+	}
+}
+
+func TestSliceReader_Next(t *testing.T) {
+	r := newSliceReader([]byte{1, 2, 3})
+	b, err := r.next(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, b)
+	assert.Equal(t, []byte{3}, remaining(r))
+
+	_, err = r.next(2)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = r.next(1)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewPacker().UnpackString(newSliceReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package bytepack
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// ErrUnsupportedKind is returned, wrapped with the offending type, when a value cannot be encoded or decoded
+var ErrUnsupportedKind = errors.New("unsupported kind")
+
+func unsupportedKindError(t reflect.Type) error {
+	return fmt.Errorf("%w %v (type %v)", ErrUnsupportedKind, t.Kind(), t)
+}
+
+type typePolicyKey struct {
+	t      reflect.Type
+	policy UnexportedFieldPolicy
+}
+
+// fieldInfo describes a single struct field the reflection-based encoder works with
+type fieldInfo struct {
+	index    int
+	exported bool
+	// encrypt is set for fields tagged with `bytepack:"encrypt"`
+	encrypt bool
+	// aad binds the sealed bytes of an encrypted field to the struct and the field
+	aad []byte
+	// lazy is set for Lazy fields
+	lazy bool
+}
+
+var structFieldsCache sync.Map // typePolicyKey -> []fieldInfo
+var checkedTypesCache sync.Map // typePolicyKey -> error
+
+// structFields returns the fields of struct type t that are encoded under the packer's unexported field policy
+func (s *Packer) structFields(t reflect.Type) []fieldInfo {
+	key := typePolicyKey{t: t, policy: s.unexportedFields}
+	if fields, ok := structFieldsCache.Load(key); ok {
+		return fields.([]fieldInfo)
+	}
+	fields := make([]fieldInfo, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() && s.unexportedFields == SkipUnexported {
+			continue
+		}
+		fi := fieldInfo{index: i, exported: sf.IsExported()}
+		fi.lazy = sf.Type.Kind() == reflect.Struct && sf.Type.Implements(lazyFieldType)
+		for _, opt := range strings.Split(sf.Tag.Get("bytepack"), ",") {
+			if opt == "encrypt" {
+				fi.encrypt = true
+				fi.aad = []byte(t.PkgPath() + "." + t.Name() + "." + sf.Name)
+			}
+		}
+		fields = append(fields, fi)
+	}
+	structFieldsCache.Store(key, fields)
+	return fields
+}
+
+// field returns the struct field described by fi, made accessible through unsafe when it is unexported.
+// Unexported fields can only be accessed in addressable structs.
+func (s *Packer) field(v reflect.Value, fi fieldInfo) reflect.Value {
+	f := v.Field(fi.index)
+	if !fi.exported {
+		f = reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+	}
+	return f
+}
+
+// checkType makes sure every type reachable from t can be encoded and decoded, so that
+// unsupported kinds are reported before any bytes are written or read
+func (s *Packer) checkType(t reflect.Type) error {
+	key := typePolicyKey{t: t, policy: s.unexportedFields}
+	if err, ok := checkedTypesCache.Load(key); ok {
+		if err == nil {
+			return nil
+		}
+		return err.(error)
+	}
+	err := s.checkTypeRec(t, make(map[reflect.Type]bool))
+	checkedTypesCache.Store(key, err)
+	return err
+}
+
+func (s *Packer) checkTypeRec(t reflect.Type, visiting map[reflect.Type]bool) error {
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr, reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return nil
+	case reflect.Interface, reflect.Chan:
+		// interfaces are checked once their dynamic type is known, channels are always left out
+		return nil
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return s.checkTypeRec(t.Elem(), visiting)
+	case reflect.Map:
+		err := s.checkTypeRec(t.Key(), visiting)
+		if err != nil {
+			return err
+		}
+		return s.checkTypeRec(t.Elem(), visiting)
+	case reflect.Struct:
+		if t.Implements(lazyFieldType) {
+			return errors.New(fmt.Sprintf("%v is only supported as a struct field", t))
+		}
+		for _, fi := range s.structFields(t) {
+			ft := t.Field(fi.index).Type
+			if fi.lazy {
+				ft = reflect.Zero(ft).Interface().(lazyField).lazyElem()
+			}
+			err := s.checkTypeRec(ft, visiting)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return unsupportedKindError(t)
+	}
+}